@@ -1,19 +1,86 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"time"
+)
 
 var (
 	// bot
-	AppID     = os.Getenv("APP_ID")
-	AppSecret = os.Getenv("APP_SECRET")
+	FeishuBaseUrl = getEnv("FEISHU_BASE_URL", "https://open.feishu.cn")
+	AppID         = os.Getenv("APP_ID")
+	AppSecret     = os.Getenv("APP_SECRET")
+	// bearer token of the /admin endpoints, which are disabled without it
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+	// record store: bitable | local
+	RecordStoreType      = getEnv("RECORD_STORE_TYPE", "bitable")
+	LocalRecordStorePath = getEnv("LOCAL_RECORD_STORE_PATH", "records.json")
 	// bitable
 	BitableAppToken = os.Getenv("BITABLE_APP_TOKEN")
 	BitableTableId  = os.Getenv("BITABLE_TABLE_ID")
 	BitableViewId   = os.Getenv("BITABLE_VIEW_ID")
+	BitablePageSize = getEnvInt("BITABLE_PAGE_SIZE", 100)
 	// cardkit
 	CardTemplateId          = os.Getenv("CARD_TEMPLATE_ID")
 	CardTemplateVersionName = os.Getenv("CARD_TEMPLATE_VERSION_NAME")
+	// card variant with a thumbnail per item, used when every item has an image or a placeholder is set
+	CardImageTemplateId          = os.Getenv("CARD_IMAGE_TEMPLATE_ID")
+	CardImageTemplateVersionName = os.Getenv("CARD_IMAGE_TEMPLATE_VERSION_NAME")
+	CardImagePlaceholderKey      = os.Getenv("CARD_IMAGE_PLACEHOLDER_KEY")
+	// card of the grouped layout, which falls back to a built-in card when unset
+	CardGroupedTemplateId          = os.Getenv("CARD_GROUPED_TEMPLATE_ID")
+	CardGroupedTemplateVersionName = os.Getenv("CARD_GROUPED_TEMPLATE_VERSION_NAME")
+
+	// feed fetching
+	FetchConcurrency     = getEnvInt("FETCH_CONCURRENCY", 16)
+	FetchHostConcurrency = getEnvInt("FETCH_HOST_CONCURRENCY", 2)
+	FetchTimeout         = time.Duration(getEnvInt("FETCH_TIMEOUT_SECONDS", 15)) * time.Second
+	FetchRetries         = getEnvInt("FETCH_RETRIES", 2)
+	FetchRetryBackoff    = time.Duration(getEnvInt("FETCH_RETRY_BACKOFF_MS", 500)) * time.Millisecond
+
+	// passphrase encrypting per-feed credentials at rest, required by /auth
+	FeedSecretKey = os.Getenv("FEED_SECRET_KEY")
+
+	// consecutive failures before a feed is suspended, 0 disables suspension
+	FeedFailureThreshold = getEnvInt("FEED_FAILURE_THRESHOLD", 10)
+
+	// time zone of absolute times in messages, e.g. Asia/Shanghai
+	TimeLocation = getEnvLocation("TIME_ZONE", time.Local)
+
+	// hard cap of items per feed, for /limit and catching up
+	CatchUpItemLimit = getEnvInt("CATCH_UP_ITEM_LIMIT", 30)
 
 	DefaultItemLimitPerFeed = 5
+	PreviewItemLimit        = 3
+	SummaryMaxLength        = getEnvInt("SUMMARY_MAX_LENGTH", 120)
+	SeenItemLimitPerFeed    = getEnvInt("SEEN_ITEM_LIMIT_PER_FEED", 200)
 	DocLink                 = "https://bqc4atlhac.feishu.cn/docx/PjPqd7Tk4o728yxqTdvc9KfanNh"
 )
+
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvLocation(key string, fallback *time.Location) *time.Location {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return fallback
+	}
+	return location
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/service"
+)
+
+const maxAdminBodySize = 10 << 20
+
+// AdminAuth guards the admin endpoints with the ADMIN_TOKEN bearer token. Without one they are disabled.
+func AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			renderAdminError(w, r, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func renderAdminError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	render.Status(r, status)
+	render.JSON(w, r, map[string]string{"error": message})
+}
+
+// getAdminTarget returns the subscriber of an admin request, given as the open_id of a user or the chat_id of a group.
+func getAdminTarget(r *http.Request) (string, bool, error) {
+	openId, chatId := r.URL.Query().Get("open_id"), r.URL.Query().Get("chat_id")
+	switch {
+	case openId != "" && chatId == "":
+		return openId, false, nil
+	case chatId != "" && openId == "":
+		return chatId, true, nil
+	default:
+		return "", false, errors.New("exactly one of open_id and chat_id is required")
+	}
+}
+
+// ExportOpml answers with the feeds of a subscriber as an OPML file.
+func ExportOpml(w http.ResponseWriter, r *http.Request) {
+	targetOpenId, isGroup, err := getAdminTarget(r)
+	if err != nil {
+		renderAdminError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recordItem, err := service.GetRecordStore().GetRecordItem(targetOpenId, isGroup)
+	if errors.Is(err, service.ErrRecordNotFound) {
+		renderAdminError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("error getting record item", err)
+		renderAdminError(w, r, http.StatusInternalServerError, getErrorReason(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+opmlFileName+`"`)
+	w.Write(service.ExportOpml(*recordItem))
+}
+
+type opmlImportResponse struct {
+	Added   []string            `json:"added"`
+	Existed []string            `json:"existed"`
+	Failed  []opmlImportFailure `json:"failed"`
+}
+
+type opmlImportFailure struct {
+	Url    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// ImportOpml subscribes a subscriber to the feeds of the OPML document in the request body.
+func ImportOpml(w http.ResponseWriter, r *http.Request) {
+	targetOpenId, isGroup, err := getAdminTarget(r)
+	if err != nil {
+		renderAdminError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+	if err != nil {
+		renderAdminError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := service.ImportOpml(targetOpenId, isGroup, data)
+	if errors.Is(err, service.ErrInvalidOpml) || errors.Is(err, service.ErrNoOpmlFeed) {
+		renderAdminError(w, r, http.StatusBadRequest, getImportErrorReason(err))
+		return
+	}
+	if err != nil {
+		log.Println("error importing opml", err)
+		renderAdminError(w, r, http.StatusInternalServerError, getErrorReason(err))
+		return
+	}
+
+	response := opmlImportResponse{
+		Added:   result.Added,
+		Existed: result.Existed,
+		Failed:  []opmlImportFailure{},
+	}
+	for _, failed := range result.Failed {
+		response.Failed = append(response.Failed, opmlImportFailure{
+			Url:    failed.Url,
+			Reason: service.GetFetchErrorReason(failed.Err),
+		})
+	}
+	render.JSON(w, r, response)
+}
+
+// DedupeRecords merges the duplicate feeds of every record, see service.DedupeRecordItemFeeds.
+func DedupeRecords(w http.ResponseWriter, r *http.Request) {
+	result, err := service.DedupeRecords()
+	if err != nil {
+		log.Println("error deduping records", err)
+		renderAdminError(w, r, http.StatusInternalServerError, getErrorReason(err))
+		return
+	}
+
+	render.JSON(w, r, result)
+}
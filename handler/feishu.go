@@ -2,10 +2,13 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-chi/render"
 	"github.com/rhinoc/rss_feishu_bot/config"
@@ -45,9 +48,13 @@ func FeishuCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		render.JSON(w, r, data)
 		return
 	}
-	log.Println("request", string(jsonData))
-
 	req, err := service.FeishuGetMessageReq(jsonData)
+	if err == nil && isCredentialCommand(req.Event.Message.Text) {
+		log.Println("request", "(redacted, the message carries feed credentials)")
+	} else {
+		log.Println("request", string(jsonData))
+	}
+
 	if err == nil {
 		go handleMessage(req)
 	}
@@ -59,36 +66,86 @@ func handleMessage(req *service.FeishuReceivedMessageRequest) {
 	// parse message
 	message := req.Event.Message
 	text := message.Text
-	log.Println("handle message text:", text)
+	log.Println("handle message text:", redactCommand(text))
 
 	// parse target
 	targetOpenId := req.Event.Sender.SenderId.OpenId
-	isGroup := message.ChatType == "group" && strings.Contains(text, " -g")
+	// a flag of its own, so that e.g. the filter rule "-gpu" isn't taken for it
+	fields := strings.Fields(text)
+	isGroup := message.ChatType == "group" && (slices.Contains(fields, "-g") || slices.Contains(fields, "--group"))
+	if message.MessageType == "file" {
+		// a file can't carry -g, so one sent to a group is for the group
+		isGroup = message.ChatType == "group"
+	}
 	if isGroup {
 		targetOpenId = req.Event.Message.ChatId
 	}
 
 	// handle command
-	if strings.Contains(text, "/list") {
+	if message.MessageType == "file" {
+		handleImportFile(message, targetOpenId, isGroup)
+		return
+	}
+
+	chatId := req.Event.Message.ChatId
+	switch getCommand(text) {
+	case "/list":
 		// command: /list [-g]
-		handleList(targetOpenId, isGroup, req.Event.Message.ChatId)
-	} else if strings.Contains(text, "/add ") {
-		// command: /add [-g] <url>
-		handleAdd(text, targetOpenId, isGroup, req.Event.Message.ChatId)
-	} else if strings.Contains(text, "/remove ") {
+		handleList(targetOpenId, isGroup, chatId)
+	case "/add":
+		// command: /add [-g] [--selector <item> ...] [--basic|--bearer|--header|--cookie <credentials>] <url>
+		handleAdd(text, targetOpenId, isGroup, chatId)
+	case "/remove":
 		// command: /remove [-g] <url>
-		handleRemove(text, targetOpenId, isGroup, req.Event.Message.ChatId)
-	} else if strings.Contains(text, "/send") {
+		handleRemove(text, targetOpenId, isGroup, chatId)
+	case "/send":
 		// command: /send [-g]
-		handleSend(targetOpenId, isGroup, req.Event.Message.ChatId)
-	} else if strings.Contains(text, "/help") {
+		handleSend(targetOpenId, isGroup, chatId)
+	case "/auth":
+		// command: /auth [-g] <url> [--basic <user>:<password>] [--bearer <token>] [--header <name>: <value>] [--cookie <cookie>] [--clear]
+		handleAuth(text, targetOpenId, isGroup, chatId)
+	case "/summary":
+		// command: /summary [-g] <url> on|off
+		handleSummary(text, targetOpenId, isGroup, chatId)
+	case "/limit":
+		// command: /limit [-g] <url> <n> [all|more|off]
+		handleLimit(text, targetOpenId, isGroup, chatId)
+	case "/filter":
+		// command: /filter [-g] <url> [rules...] [--clear]
+		handleFilter(text, targetOpenId, isGroup, chatId)
+	case "/alert":
+		// command: /alert [-g] [list | add <rule> @user... | remove <n>]
+		handleAlert(text, message.Mentions, targetOpenId, isGroup, chatId)
+	case "/layout":
+		// command: /layout [-g] [flat|grouped] [--collapse]
+		handleLayout(text, targetOpenId, isGroup, chatId)
+	case "/export":
+		// command: /export [-g]
+		handleExport(targetOpenId, isGroup, chatId)
+	case "/import":
+		// command: /import [-g] <url>
+		handleImport(text, targetOpenId, isGroup, chatId)
+	case "/help":
 		// command: /help
-		handleHelp(req.Event.Message.ChatId)
+		handleHelp(chatId)
+	}
+}
+
+// getCommand returns the first word of a message, e.g. "/add", skipping the mentions of the bot before it,
+// so that arguments such as urls and filter rules are never taken for commands.
+func getCommand(text string) string {
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "@") {
+			continue
+		}
+		return field
 	}
+	return ""
 }
 
 func handleList(targetOpenId string, isGroup bool, chatId string) {
-	recordItem, err := service.GetRecordItem(targetOpenId, isGroup)
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
 	if err != nil || len(recordItem.FeedList) == 0 {
 		log.Println("error getting record item", err)
 		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
@@ -97,10 +154,7 @@ func handleList(targetOpenId string, isGroup bool, chatId string) {
 
 	items := []model.FeishuMessageItem{}
 	for _, feed := range recordItem.FeedList {
-		items = append(items, model.FeishuMessageItem{
-			Title: feed.Link,
-			Link:  feed.Link,
-		})
+		items = append(items, getFeedHealthMessageItem(feed))
 	}
 
 	content := &model.FeishuMessageContent{
@@ -125,14 +179,83 @@ func handleList(targetOpenId string, isGroup bool, chatId string) {
 }
 
 func handleAdd(text string, targetOpenId string, isGroup bool, chatId string) {
-	url := util.ExtractUrl(text)
+	flags, rest := parseCommandFlags(text, append([]string{"--selector", "--title", "--link"}, authFlags...)...)
+	url, err := extractFeedLink(rest)
+	if err != nil {
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	}
 	if url == "" {
 		log.Println("no url found")
 		service.FeishuSendMessageText(chatId, "chat_id", "Please provide a valid URL")
 		return
 	}
 
-	recordItem, err := service.GetRecordItem(targetOpenId, isGroup)
+	// command: /add [-g] --selector <item> [--title <title>] [--link <link>] <url>
+	var selector *service.RecordItemFeedSelector
+	if flags["--selector"] != "" {
+		selector = &service.RecordItemFeedSelector{
+			Item:  flags["--selector"],
+			Title: flags["--title"],
+			Link:  flags["--link"],
+		}
+		if err := selector.Validate(); err != nil {
+			service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+			return
+		}
+	}
+
+	// command: /add [-g] [--basic <user>:<password>] [--bearer <token>] [--header <name>: <value>] [--cookie <cookie>] <url>
+	auth, err := parseAuthFlags(flags)
+	if err != nil {
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	}
+	if auth != nil {
+		auth = mergeFeedAuth(nil, auth)
+	}
+
+	var feed *service.RssFeed
+	if selector == nil && auth == nil && service.GetSourceAdapter(url) == nil {
+		// the url may be a web page advertising one or more feeds
+		feeds, err := service.DiscoverFeeds(url)
+		if err != nil {
+			log.Println("error discovering feed", url, err)
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s (%s)", url, service.GetFetchErrorReason(err)))
+			return
+		}
+		if len(feeds) > 1 {
+			handleFeedChoice(url, feeds, isGroup, chatId)
+			return
+		}
+
+		url = feeds[0].Link
+		feed = feeds[0].Feed
+	}
+
+	newFeed := &service.RecordItemFeed{
+		Link:     url,
+		Selector: selector,
+		Auth:     auth,
+	}
+	if feed == nil {
+		result, err := service.ValidateFeed(service.RssFetchRequest{Url: url, Selector: selector, Auth: auth})
+		if err != nil {
+			log.Println("error validating feed", url, err)
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s (%s)", url, service.GetFetchErrorReason(err)))
+			return
+		}
+		feed = result.Feed
+		newFeed.SetResolvedLink(result.Url)
+	}
+
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
+	if err != nil && !errors.Is(err, service.ErrRecordNotFound) {
+		log.Println("error getting record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s (%s)", url, getErrorReason(err)))
+		return
+	}
 	if err != nil {
 		// create new record item
 		userOpenId := targetOpenId
@@ -148,55 +271,139 @@ func handleAdd(text string, targetOpenId string, isGroup bool, chatId string) {
 		recordItem = &service.RecordItem{
 			UserOpenId:  userOpenId,
 			GroupOpenId: groupOpenId,
-			FeedList: []*service.RecordItemFeed{
-				{
-					Link: url,
-				},
-			},
+			FeedList:    []*service.RecordItemFeed{newFeed},
 		}
 
-		_, err = service.AddRecordItem(*recordItem)
+		_, err = store.AddRecordItem(*recordItem)
 		if err != nil {
 			log.Println("error adding record item", err)
-			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s", url))
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s (%s)", url, getErrorReason(err)))
 			return
 		}
 
-		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully added subscription: %s", url))
+		sendSubscriptionPreview(url, feed, chatId)
 		return
 	}
 
-	// check if the url is already in the feed list
-	for _, feed := range recordItem.FeedList {
-		if feed.Link == url {
-			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has already been subscribed: %s", url))
+	// check if the url, or where it redirects to, is already in the feed list
+	recordItemFeed := recordItem.FindFeed(url)
+	if recordItemFeed == nil && newFeed.ResolvedLink != "" {
+		recordItemFeed = recordItem.FindFeed(newFeed.ResolvedLink)
+	}
+	if recordItemFeed != nil {
+		if recordItemFeed.Suspended {
+			handleResume(*recordItem, recordItemFeed, chatId)
 			return
 		}
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has already been subscribed: %s", recordItemFeed.Link))
+		return
 	}
 
 	// add the url to the feed list
-	recordItem.FeedList = append(recordItem.FeedList, &service.RecordItemFeed{
-		Link: url,
+	recordItem.FeedList = append(recordItem.FeedList, newFeed)
+
+	err = store.UpdateRecordItemFeedList(*recordItem)
+	if err != nil {
+		log.Println("error updating record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s (%s)", url, getErrorReason(err)))
+		return
+	}
+
+	sendSubscriptionPreview(url, feed, chatId)
+}
+
+func sendSubscriptionPreview(url string, feed *service.RssFeed, chatId string) {
+	now := time.Now()
+	items := []model.FeishuMessageItem{}
+	for _, item := range feed.Items {
+		if len(items) >= config.PreviewItemLimit {
+			break
+		}
+		items = append(items, model.FeishuMessageItem{
+			Title:            item.Title,
+			Link:             item.Link,
+			PrimaryDesc:      feed.Title,
+			PrimaryDescColor: "blue",
+			SecondaryDesc:    item.TimeLabel(now),
+			Tags:             service.GetPodcastMarkdown(item),
+		})
+	}
+
+	title := feed.Title
+	if title == "" {
+		title = url
+	}
+
+	content := &model.FeishuMessageContent{
+		Type: "template",
+		Data: model.FeishuMessageData{
+			TemplateId:          config.CardTemplateId,
+			TemplateVersionName: config.CardTemplateVersionName,
+			TemplateVariable: map[string]interface{}{
+				"cardTitle":    fmt.Sprintf("Successfully subscribed: %s", title),
+				"cardSubTitle": url,
+				"cardColor":    "green",
+				"itemList":     items,
+			},
+		},
+	}
+
+	err := service.FeishuSendMessage(service.FeishuSendMessageRequest{
+		ReceiveId:     chatId,
+		ReceiveIdType: "chat_id",
+		MsgType:       "interactive",
+		Content:       string(util.Must(json.Marshal(content))),
 	})
+	if err != nil {
+		log.Println("error sending subscription preview", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully added subscription: %s", url))
+	}
+}
 
-	err = service.UpdateRecordItemFeedList(*recordItem)
+func handleFeedChoice(url string, feeds []service.DiscoveredFeed, isGroup bool, chatId string) {
+	command := "/add"
+	if isGroup {
+		command = "/add -g"
+	}
+
+	lines := []string{fmt.Sprintf("Found %d feeds on %s, send one of the following to subscribe:", len(feeds), url)}
+	for _, feed := range feeds {
+		line := fmt.Sprintf("%s %s", command, feed.Link)
+		if feed.Title != "" {
+			line += fmt.Sprintf(" (%s)", feed.Title)
+		}
+		lines = append(lines, line)
+	}
+
+	service.FeishuSendMessageText(chatId, "chat_id", strings.Join(lines, "\n"))
+}
+
+func handleResume(recordItem service.RecordItem, feed *service.RecordItemFeed, chatId string) {
+	feed.Resume()
+	err := service.GetRecordStore().UpdateRecordItemFeedList(recordItem)
 	if err != nil {
 		log.Println("error updating record item", err)
-		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to add subscription: %s", url))
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to resume subscription: %s (%s)", feed.Link, getErrorReason(err)))
 		return
 	}
 
-	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully added subscription: %s", url))
+	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully resumed subscription: %s", feed.Link))
 }
 
 func handleRemove(text string, targetOpenId string, isGroup bool, chatId string) {
-	url := util.ExtractUrl(text)
+	url, err := extractFeedLink(text)
+	if err != nil {
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	}
 	if url == "" {
 		log.Println("no url found")
 		service.FeishuSendMessageText(chatId, "chat_id", "Please provide a valid URL")
 		return
 	}
-	recordItem, err := service.GetRecordItem(targetOpenId, isGroup)
+
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
 	if err != nil {
 		log.Println("error getting record item", err)
 		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
@@ -204,39 +411,117 @@ func handleRemove(text string, targetOpenId string, isGroup bool, chatId string)
 	}
 
 	// check if the url is in the feed list
-	for i, feed := range recordItem.FeedList {
-		if feed.Link == url {
-			recordItem.FeedList = append(recordItem.FeedList[:i], recordItem.FeedList[i+1:]...)
-			err = service.UpdateRecordItemFeedList(*recordItem)
-			if err != nil {
-				log.Println("error updating record item", err)
-				service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to remove subscription: %s", url))
-				return
-			}
-
-			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully removed subscription: %s", url))
-			return
-		}
+	removed := recordItem.FindFeed(url)
+	if removed == nil {
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has not been subscribed yet: %s", url))
+		return
 	}
 
-	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has not been subscribed yet: %s", url))
+	recordItem.FeedList = slices.DeleteFunc(recordItem.FeedList, func(feed *service.RecordItemFeed) bool {
+		return feed == removed
+	})
+	err = store.UpdateRecordItemFeedList(*recordItem)
+	if err != nil {
+		log.Println("error updating record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to remove subscription: %s (%s)", url, getErrorReason(err)))
+		return
+	}
+
+	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Successfully removed subscription: %s", removed.Link))
 }
 
 func handleSend(targetOpenId string, isGroup bool, chatId string) {
-	recordItem, err := service.GetRecordItem(targetOpenId, isGroup)
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
 	if err != nil || len(recordItem.FeedList) == 0 {
 		log.Println("error getting record item", err)
 		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
 		return
 	}
 
-	err = service.SendRssMessageByRecord(*recordItem)
+	err = service.SendRssMessageByRecord(*recordItem, nil)
 	if err != nil {
 		log.Println("error sending rss message", err)
-		service.FeishuSendMessageText(chatId, "chat_id", "Failed to send RSS message")
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to send RSS message (%s)", getErrorReason(err)))
 	}
 }
 
 func handleHelp(chatId string) {
 	service.FeishuSendMessageText(chatId, "chat_id", config.DocLink)
 }
+
+// parseCommandFlags picks the values of the given flags out of a command, which may be quoted,
+// and returns them with the rest of the command.
+func parseCommandFlags(text string, names ...string) (map[string]string, string) {
+	flags := make(map[string]string)
+	rest := []string{}
+
+	args := util.SplitCommandArgs(text)
+	for i := 0; i < len(args); i++ {
+		if slices.Contains(names, args[i]) && i+1 < len(args) {
+			flags[args[i]] = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+
+	return flags, strings.Join(rest, " ")
+}
+
+// extractFeedLink returns the source uri, e.g. github:golang/go/releases, or the url a command refers to.
+func extractFeedLink(text string) (string, error) {
+	uri, err := service.ExtractSourceUri(text)
+	if err != nil || uri != "" {
+		return uri, err
+	}
+	return util.TrimUrl(util.ExtractUrl(text)), nil
+}
+
+func getFeedHealthMessageItem(feed *service.RecordItemFeed) model.FeishuMessageItem {
+	item := model.FeishuMessageItem{
+		Title: feed.Link,
+		Link:  feed.Link,
+	}
+
+	switch feed.HealthStatus() {
+	case service.FeedHealthHealthy:
+		item.PrimaryDesc = "healthy"
+		item.PrimaryDescColor = "green"
+		item.SecondaryDesc = fmt.Sprintf("last success %s", util.FormatRelativeTime(*feed.LastSuccessAt, time.Now(), config.TimeLocation))
+	case service.FeedHealthFailing:
+		item.PrimaryDesc = fmt.Sprintf("failing x%d", feed.FailureCount)
+		item.PrimaryDescColor = "orange"
+		item.SecondaryDesc = feed.LastError
+	case service.FeedHealthSuspended:
+		item.PrimaryDesc = "suspended"
+		item.PrimaryDescColor = "red"
+		item.SecondaryDesc = feed.LastError
+	default:
+		item.PrimaryDesc = "pending"
+		item.PrimaryDescColor = "neutral"
+	}
+
+	// only whether credentials are set, never their values
+	if !feed.Auth.IsEmpty() {
+		item.Tags += "<text_tag color='neutral'>auth</text_tag>"
+	}
+	if len(feed.Filters) > 0 {
+		item.Tags += fmt.Sprintf("<text_tag color='neutral'>%s</text_tag>", util.EscapeMarkdown(strings.Join(feed.Filters, " ")))
+	}
+
+	return item
+}
+
+func getErrorReason(err error) string {
+	switch {
+	case errors.Is(err, service.ErrFeishuPermissionDenied):
+		return "the bot lacks permission, please check its scopes and access"
+	case errors.Is(err, service.ErrFeishuRateLimited):
+		return "rate limited by Feishu, please retry later"
+	case errors.Is(err, service.ErrFeishuNotFound):
+		return "the subscription table was not found"
+	default:
+		return "unexpected error"
+	}
+}
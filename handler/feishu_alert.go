@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/rhinoc/rss_feishu_bot/service"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+const alertUsage = "Usage: /alert [-g] add <word|/regex/> @user..., /alert [-g] remove <n> or /alert [-g] list"
+
+func handleAlert(text string, mentions []service.FeishuMention, targetOpenId string, isGroup bool, chatId string) {
+	// the bot itself is mentioned before the command in groups
+	_, command, _ := strings.Cut(text, "/alert")
+
+	args := []string{}
+	for _, arg := range util.SplitCommandArgs(command) {
+		if arg != "-g" && arg != "--group" {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
+	if err != nil {
+		log.Println("error getting record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
+		return
+	}
+
+	alertList := recordItem.Settings.AlertList
+	switch args[0] {
+	case "list":
+		service.FeishuSendMessageText(chatId, "chat_id", getAlertListText(alertList))
+		return
+	case "add":
+		alert, err := parseAlert(args[1:], mentions)
+		if err != nil {
+			service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+			return
+		}
+		alertList = append(alertList, alert)
+	case "remove":
+		index := 0
+		if len(args) > 1 {
+			index, _ = strconv.Atoi(args[1])
+		}
+		if index < 1 || index > len(alertList) {
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("No such alert: %d\n%s", index, getAlertListText(alertList)))
+			return
+		}
+		alertList = append(alertList[:index-1:index-1], alertList[index:]...)
+	default:
+		service.FeishuSendMessageText(chatId, "chat_id", alertUsage)
+		return
+	}
+
+	recordItem.Settings.AlertList = alertList
+	err = store.UpdateRecordItemSettings(*recordItem)
+	if err != nil {
+		log.Println("error updating record item settings", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to update alerts (%s)", getErrorReason(err)))
+		return
+	}
+
+	service.FeishuSendMessageText(chatId, "chat_id", getAlertListText(alertList))
+}
+
+// parseAlert reads the rule and the mentioned users, whose keys stand in for their names in args.
+func parseAlert(args []string, mentions []service.FeishuMention) (*service.RecordItemAlert, error) {
+	alert := &service.RecordItemAlert{}
+	rules := []string{}
+	for _, arg := range args {
+		mentioned := false
+		for _, mention := range mentions {
+			if arg == mention.Key {
+				alert.Mentions = append(alert.Mentions, service.RecordItemAlertMention{
+					OpenId: mention.Id.OpenId,
+					Name:   mention.Name,
+				})
+				mentioned = true
+				break
+			}
+		}
+		if !mentioned {
+			rules = append(rules, arg)
+		}
+	}
+
+	if len(rules) != 1 {
+		return nil, errors.New(alertUsage)
+	}
+	if err := service.ValidateAlertRule(rules[0]); err != nil {
+		return nil, err
+	}
+	alert.Rule = rules[0]
+
+	return alert, nil
+}
+
+func getAlertListText(alertList []*service.RecordItemAlert) string {
+	if len(alertList) == 0 {
+		return "No alerts set"
+	}
+
+	lines := []string{"Alerts:"}
+	for i, alert := range alertList {
+		line := fmt.Sprintf("%d. %s", i+1, alert.Rule)
+		names := []string{}
+		for _, mention := range alert.Mentions {
+			names = append(names, "@"+mention.Name)
+		}
+		if len(names) > 0 {
+			line += fmt.Sprintf(" (%s)", strings.Join(names, " "))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
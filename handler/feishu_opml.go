@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/rhinoc/rss_feishu_bot/service"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+const opmlFileName = "subscriptions.opml"
+
+func handleExport(targetOpenId string, isGroup bool, chatId string) {
+	recordItem, err := service.GetRecordStore().GetRecordItem(targetOpenId, isGroup)
+	if err != nil || len(recordItem.FeedList) == 0 {
+		log.Println("error getting record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
+		return
+	}
+
+	fileKey, err := service.FeishuUploadFile(opmlFileName, service.ExportOpml(*recordItem))
+	if err != nil {
+		log.Println("error uploading opml", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to export subscriptions (%s)", getErrorReason(err)))
+		return
+	}
+
+	err = service.FeishuSendMessageFile(chatId, "chat_id", fileKey)
+	if err != nil {
+		log.Println("error sending opml", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to export subscriptions (%s)", getErrorReason(err)))
+	}
+}
+
+func handleImport(text string, targetOpenId string, isGroup bool, chatId string) {
+	url := util.ExtractUrl(text)
+	if url == "" {
+		service.FeishuSendMessageText(chatId, "chat_id", "Usage: /import [-g] <url>, or send an OPML file to the bot")
+		return
+	}
+
+	data, err := service.DownloadOpml(url)
+	if err != nil {
+		log.Println("error downloading opml", url, err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to import: %s (%s)", url, service.GetFetchErrorReason(err)))
+		return
+	}
+
+	importOpml(data, targetOpenId, isGroup, chatId)
+}
+
+func handleImportFile(message service.FeishuReceivedMessage, targetOpenId string, isGroup bool) {
+	chatId := message.ChatId
+	switch strings.ToLower(path.Ext(message.FileName)) {
+	case ".opml", ".xml":
+	default:
+		service.FeishuSendMessageText(chatId, "chat_id", "Only OPML files can be imported")
+		return
+	}
+
+	data, err := service.FeishuGetMessageResource(message.MessageId, message.FileKey)
+	if err != nil {
+		log.Println("error downloading message file", message.FileName, err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to import: %s (%s)", message.FileName, getErrorReason(err)))
+		return
+	}
+
+	importOpml(data, targetOpenId, isGroup, chatId)
+}
+
+func importOpml(data []byte, targetOpenId string, isGroup bool, chatId string) {
+	service.FeishuSendMessageText(chatId, "chat_id", "Importing, each feed is checked first...")
+
+	result, err := service.ImportOpml(targetOpenId, isGroup, data)
+	if err != nil {
+		log.Println("error importing opml", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to import (%s)", getImportErrorReason(err)))
+		return
+	}
+
+	lines := []string{fmt.Sprintf("Imported %d feeds, %d already subscribed, %d failed", len(result.Added), len(result.Existed), len(result.Failed))}
+	for _, failed := range result.Failed {
+		lines = append(lines, fmt.Sprintf("- %s (%s)", failed.Url, service.GetFetchErrorReason(failed.Err)))
+	}
+	service.FeishuSendMessageText(chatId, "chat_id", strings.Join(lines, "\n"))
+}
+
+func getImportErrorReason(err error) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidOpml):
+		return service.ErrInvalidOpml.Error()
+	case errors.Is(err, service.ErrNoOpmlFeed):
+		return service.ErrNoOpmlFeed.Error()
+	default:
+		return getErrorReason(err)
+	}
+}
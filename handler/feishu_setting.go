@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/service"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+// updateSubscribedFeed applies update to the subscribed feed with url and saves it,
+// replying to chatId itself when that fails.
+func updateSubscribedFeed(targetOpenId string, isGroup bool, chatId string, url string, update func(feed *service.RecordItemFeed)) bool {
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
+	if err != nil {
+		log.Println("error getting record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
+		return false
+	}
+
+	feed := recordItem.FindFeed(url)
+	if feed == nil {
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has not been subscribed yet: %s", url))
+		return false
+	}
+
+	update(feed)
+	err = store.UpdateRecordItemFeedList(*recordItem)
+	if err != nil {
+		log.Println("error updating record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to update subscription: %s (%s)", url, getErrorReason(err)))
+		return false
+	}
+
+	return true
+}
+
+func handleSummary(text string, targetOpenId string, isGroup bool, chatId string) {
+	fields := strings.Fields(text)
+	option := fields[len(fields)-1]
+	if option != "on" && option != "off" {
+		service.FeishuSendMessageText(chatId, "chat_id", "Usage: /summary [-g] <url> on|off")
+		return
+	}
+
+	// the option would otherwise be read as an argument of a source uri
+	url, err := extractFeedLink(strings.Join(fields[:len(fields)-1], " "))
+	switch {
+	case err != nil:
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	case url == "":
+		service.FeishuSendMessageText(chatId, "chat_id", "Please provide a valid URL")
+		return
+	}
+
+	showSummary := option == "on"
+	ok := updateSubscribedFeed(targetOpenId, isGroup, chatId, url, func(feed *service.RecordItemFeed) {
+		feed.ShowSummary = showSummary
+	})
+	if !ok {
+		return
+	}
+
+	if showSummary {
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Summaries turned on for: %s", url))
+	} else {
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Summaries turned off for: %s", url))
+	}
+}
+
+func handleLimit(text string, targetOpenId string, isGroup bool, chatId string) {
+	usage := fmt.Sprintf("Usage: /limit [-g] <url> <n> [all|more|off], with n from 0 (default %d) to %d", config.DefaultItemLimitPerFeed, config.CatchUpItemLimit)
+
+	fields := strings.Fields(text)
+	var catchUp *service.FeedCatchUpMode
+	if len(fields) > 0 {
+		if mode, err := service.ParseFeedCatchUpMode(fields[len(fields)-1]); err == nil {
+			catchUp = &mode
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if len(fields) == 0 {
+		service.FeishuSendMessageText(chatId, "chat_id", usage)
+		return
+	}
+	limit, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || limit < 0 || limit > config.CatchUpItemLimit {
+		service.FeishuSendMessageText(chatId, "chat_id", usage)
+		return
+	}
+
+	// the options would otherwise be read as arguments of a source uri
+	url, err := extractFeedLink(strings.Join(fields[:len(fields)-1], " "))
+	switch {
+	case err != nil:
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	case url == "":
+		service.FeishuSendMessageText(chatId, "chat_id", "Please provide a valid URL")
+		return
+	}
+
+	var itemLimit int
+	var mode service.FeedCatchUpMode
+	ok := updateSubscribedFeed(targetOpenId, isGroup, chatId, url, func(feed *service.RecordItemFeed) {
+		feed.ItemLimit = limit
+		if catchUp != nil {
+			feed.CatchUp = *catchUp
+		}
+		itemLimit, mode = feed.GetItemLimit(), feed.CatchUp
+	})
+	if !ok {
+		return
+	}
+
+	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Item limit of %s: %d, catch-up: %s", url, itemLimit, mode))
+}
+
+func handleFilter(text string, targetOpenId string, isGroup bool, chatId string) {
+	_, command, _ := strings.Cut(text, "/filter")
+
+	args := []string{}
+	reset := false
+	for _, arg := range util.SplitCommandArgs(command) {
+		switch arg {
+		case "--clear":
+			reset = true
+		case "-g", "--group":
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	url, rules, err := splitFeedLinkArgs(args)
+	switch {
+	case err != nil:
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	case url == "":
+		service.FeishuSendMessageText(chatId, "chat_id", `Usage: /filter [-g] <url> [word] [+word] [-word] [/regex/] [title:|description:|author:|category:<word>] [--clear]`)
+		return
+	}
+	for _, rule := range rules {
+		if _, err := service.ParseFeedFilterRule(rule); err != nil {
+			service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+			return
+		}
+	}
+
+	var filters []string
+	if reset || len(rules) > 0 {
+		ok := updateSubscribedFeed(targetOpenId, isGroup, chatId, url, func(feed *service.RecordItemFeed) {
+			feed.Filters = rules
+		})
+		if !ok {
+			return
+		}
+		filters = rules
+	} else {
+		// without rules, only show the current ones
+		recordItem, err := service.GetRecordStore().GetRecordItem(targetOpenId, isGroup)
+		if err != nil || recordItem.FindFeed(url) == nil {
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("This URL has not been subscribed yet: %s", url))
+			return
+		}
+		filters = recordItem.FindFeed(url).Filters
+	}
+
+	if len(filters) == 0 {
+		service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("No filters on: %s", url))
+		return
+	}
+	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Filters of %s: %s", url, strings.Join(filters, " ")))
+}
+
+func handleLayout(text string, targetOpenId string, isGroup bool, chatId string) {
+	_, command, _ := strings.Cut(text, "/layout")
+
+	var layout *service.DigestLayout
+	collapse := false
+	for _, arg := range strings.Fields(command) {
+		switch arg {
+		case "-g", "--group":
+		case "--collapse":
+			collapse = true
+		default:
+			parsed, err := service.ParseDigestLayout(arg)
+			if err != nil {
+				service.FeishuSendMessageText(chatId, "chat_id", "Usage: /layout [-g] flat|grouped [--collapse]")
+				return
+			}
+			layout = &parsed
+		}
+	}
+
+	store := service.GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
+	if err != nil {
+		log.Println("error getting record item", err)
+		service.FeishuSendMessageText(chatId, "chat_id", "No subscribed feeds found")
+		return
+	}
+
+	// without a layout, only show the current one
+	if layout != nil {
+		recordItem.Settings.Layout = *layout
+		recordItem.Settings.CollapseSections = collapse && *layout == service.DigestLayoutGrouped
+		err = store.UpdateRecordItemSettings(*recordItem)
+		if err != nil {
+			log.Println("error updating record item settings", err)
+			service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Failed to update layout (%s)", getErrorReason(err)))
+			return
+		}
+	}
+
+	message := fmt.Sprintf("Layout: %s", recordItem.Settings.Layout)
+	if recordItem.Settings.CollapseSections {
+		message += ", sections collapsed"
+	}
+	service.FeishuSendMessageText(chatId, "chat_id", message)
+}
+
+// splitFeedLinkArgs reads the url or source uri the arguments start with, and returns the arguments after it.
+// A source uri takes as many of them as make a valid one, e.g. "github:golang/go releases".
+func splitFeedLinkArgs(args []string) (string, []string, error) {
+	if len(args) == 0 || service.GetSourceAdapter(args[0]) == nil {
+		if len(args) == 0 || util.ExtractUrl(args[0]) == "" {
+			return "", nil, nil
+		}
+		url, err := extractFeedLink(args[0])
+		return url, args[1:], err
+	}
+
+	url, end, err := "", 0, error(nil)
+	for i := 1; i <= len(args); i++ {
+		// like ExtractSourceUri, stop at signed rules, flags and regexes
+		if i > 1 && strings.IndexAny(args[i-1], "+-/") == 0 {
+			break
+		}
+		uri, uriErr := extractFeedLink(strings.Join(args[:i], " "))
+		if uriErr != nil {
+			if i == 1 {
+				err = uriErr
+			}
+			continue
+		}
+		url, end, err = uri, i, nil
+	}
+	return url, args[end:], err
+}
+
+var authFlags = []string{"--basic", "--bearer", "--header", "--cookie"}
+
+func isCredentialCommand(text string) bool {
+	if strings.Contains(text, "/auth ") {
+		return true
+	}
+	for _, flag := range authFlags {
+		if strings.Contains(text, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCommand hides commands carrying credentials, so they never reach the logs.
+func redactCommand(text string) string {
+	if isCredentialCommand(text) {
+		return "(redacted, the command carries feed credentials)"
+	}
+	return text
+}
+
+// parseAuthFlags returns the credentials given by the flags of a command, or nil when there are none.
+// A header with an empty value is kept, so that mergeFeedAuth removes it.
+func parseAuthFlags(flags map[string]string) (*service.RecordItemFeedAuth, error) {
+	auth := &service.RecordItemFeedAuth{}
+	found := false
+
+	if basic, ok := flags["--basic"]; ok {
+		auth.Username, auth.Password, _ = strings.Cut(basic, ":")
+		found = true
+	}
+	if token, ok := flags["--bearer"]; ok {
+		auth.Token = token
+		found = true
+	}
+	if cookie, ok := flags["--cookie"]; ok {
+		auth.Cookie = cookie
+		found = true
+	}
+	if header, ok := flags["--header"]; ok {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf(`Please provide the header as "<name>: <value>"`)
+		}
+		auth.Headers = map[string]string{name: strings.TrimSpace(value)}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	if config.FeedSecretKey == "" {
+		return nil, fmt.Errorf("Credentials can't be stored: %w", service.ErrFeedSecretKeyMissing)
+	}
+	return auth, nil
+}
+
+// mergeFeedAuth applies the credentials of update over auth, and returns nil when nothing is left.
+func mergeFeedAuth(auth *service.RecordItemFeedAuth, update *service.RecordItemFeedAuth) *service.RecordItemFeedAuth {
+	if auth == nil || auth.IsUnreadable() {
+		auth = &service.RecordItemFeedAuth{}
+	}
+
+	if update.Username != "" || update.Password != "" {
+		auth.Username, auth.Password = update.Username, update.Password
+	}
+	if update.Token != "" {
+		auth.Token = update.Token
+	}
+	if update.Cookie != "" {
+		auth.Cookie = update.Cookie
+	}
+	for name, value := range update.Headers {
+		if auth.Headers == nil {
+			auth.Headers = make(map[string]string)
+		}
+		if value == "" {
+			delete(auth.Headers, name)
+		} else {
+			auth.Headers[name] = value
+		}
+	}
+
+	if auth.IsEmpty() {
+		return nil
+	}
+	return auth
+}
+
+func handleAuth(text string, targetOpenId string, isGroup bool, chatId string) {
+	flags, rest := parseCommandFlags(text, authFlags...)
+	reset := slices.Contains(strings.Fields(rest), "--clear")
+	if len(flags) == 0 && !reset {
+		service.FeishuSendMessageText(chatId, "chat_id", `Usage: /auth [-g] <url> [--basic "<user>:<password>"] [--bearer <token>] [--header "<name>: <value>"] [--cookie "<cookie>"] [--clear]`)
+		return
+	}
+
+	url, err := extractFeedLink(rest)
+	switch {
+	case err != nil:
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	case url == "":
+		service.FeishuSendMessageText(chatId, "chat_id", "Please provide a valid URL")
+		return
+	}
+
+	update, err := parseAuthFlags(flags)
+	if err != nil {
+		service.FeishuSendMessageText(chatId, "chat_id", err.Error())
+		return
+	}
+
+	var auth *service.RecordItemFeedAuth
+	ok := updateSubscribedFeed(targetOpenId, isGroup, chatId, url, func(feed *service.RecordItemFeed) {
+		if reset {
+			feed.Auth = nil
+		}
+		if update != nil {
+			feed.Auth = mergeFeedAuth(feed.Auth, update)
+		}
+		// the validators were issued for the previous credentials
+		feed.ETag = ""
+		feed.LastModified = ""
+		auth = feed.Auth
+	})
+	if !ok {
+		return
+	}
+
+	service.FeishuSendMessageText(chatId, "chat_id", fmt.Sprintf("Credentials for %s: %s", url, auth.Describe()))
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func GetRecordList(w http.ResponseWriter, r *http.Request) {
-	recordItems, err := service.GetRecordList()
+	recordItems, err := service.GetRecordStore().GetRecordList()
 	if err != nil {
 		log.Println("error getting record list", err)
 		render.Status(r, http.StatusInternalServerError)
@@ -8,17 +8,20 @@ import (
 )
 
 func SendRssMessage(w http.ResponseWriter, r *http.Request) {
-	records, err := service.GetRecordList()
-	if err != nil {
-		log.Println("error getting record list", err)
-		return
-	}
-
-	for _, record := range records {
-		err := service.SendRssMessageByRecord(record)
+	// every record of this run shares the fetched feeds
+	cache := service.NewRssFetchCache()
+	err := service.GetRecordStore().ForEachRecordItem(func(record service.RecordItem) error {
+		err := service.SendRssMessageByRecord(record, cache)
 		if err != nil {
 			log.Println("error sending rss message for record", record.Id, err)
 		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error getting record list", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
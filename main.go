@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"os"
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -22,6 +23,12 @@ func main() {
 	r.Route("/record", func(r chi.Router) {
 		r.Get("/list", handler.GetRecordList)
 	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(handler.AdminAuth)
+		r.Get("/opml", handler.ExportOpml)
+		r.Post("/opml", handler.ImportOpml)
+		r.Post("/dedupe", handler.DedupeRecords)
+	})
 
 	port := os.Getenv("PORT")
 	if port == "" {
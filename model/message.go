@@ -17,4 +17,10 @@ type FeishuMessageItem struct {
 	PrimaryDesc      string `json:"primaryDesc"`
 	PrimaryDescColor string `json:"primaryDescColor"`
 	SecondaryDesc    string `json:"secondaryDesc"`
+	// extra inline markdown after the tags, e.g. a podcast duration and play link
+	Tags string `json:"tags"`
+	// markdown appended below the item, starting with a line break when set
+	Summary string `json:"summary"`
+	// only rendered by the image card variant
+	ImageKey string `json:"imageKey,omitempty"`
 }
@@ -1,11 +1,9 @@
 package service
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/rhinoc/rss_feishu_bot/config"
@@ -13,12 +11,17 @@ import (
 
 // Add these variables at the package level
 var (
+	// held while fetching, as e.g. images are uploaded in parallel
+	tokenMu     sync.Mutex
 	cachedToken string
 	tokenExpiry time.Time
 )
 
 func GetAccessToken() (string, error) {
-	url := "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal"
+	url := config.FeishuBaseUrl + "/open-apis/auth/v3/tenant_access_token/internal"
+
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
 
 	// Check if we have a cached token that's still valid
 	if cachedToken != "" && time.Now().Before(tokenExpiry) {
@@ -30,23 +33,6 @@ func GetAccessToken() (string, error) {
 		"app_id":     config.AppID,
 		"app_secret": config.AppSecret,
 	}
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
-	}
-
-	// Make the HTTP POST request
-	resp, err := http.Post(url, "application/json; charset=utf-8", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Read and parse the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
 
 	var response struct {
 		Code              int    `json:"code"`
@@ -55,14 +41,10 @@ func GetAccessToken() (string, error) {
 		Expire            int    `json:"expire"`
 	}
 
+	body, err := feishuDo(http.MethodPost, url, requestBody, false, &response)
 	fmt.Println("access token response", string(body))
-	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %v", err)
-	}
-
-	if response.Code != 0 {
-		return "", fmt.Errorf("API error: %s", response.Msg)
+		return "", err
 	}
 
 	// Cache the token and set expiry time
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// RecordItemAlert sends the items matching Rule right away in a message of their own, mentioning people,
+// rather than in the digest.
+type RecordItemAlert struct {
+	// a filter rule without sign, e.g. "CVE" or "title:/cve-\d+/", see FeedFilterRule
+	Rule     string                   `json:"rule"`
+	Mentions []RecordItemAlertMention `json:"mentions,omitempty"`
+}
+
+type RecordItemAlertMention struct {
+	OpenId string `json:"open_id"`
+	Name   string `json:"name"`
+}
+
+// ValidateAlertRule accepts any filter rule that includes items.
+func ValidateAlertRule(rule string) error {
+	parsed, err := ParseFeedFilterRule(rule)
+	if err != nil {
+		return err
+	}
+	if parsed.Exclude {
+		return errors.New("an alert rule can't exclude items")
+	}
+	return nil
+}
+
+func (alert *RecordItemAlert) match(item RssFeedItem) bool {
+	rule, err := ParseFeedFilterRule(alert.Rule)
+	if err != nil {
+		log.Println("error parsing alert rule", alert.Rule, err)
+		return false
+	}
+	return rule.match(item)
+}
+
+// GetMentionMarkdown renders the mentions of the alert for a text message.
+func (alert *RecordItemAlert) GetMentionMarkdown() string {
+	mentions := []string{}
+	for _, mention := range alert.Mentions {
+		mentions = append(mentions, fmt.Sprintf(`<at user_id="%s">%s</at>`, mention.OpenId, mention.Name))
+	}
+	return strings.Join(mentions, " ")
+}
+
+func getMatchingAlerts(alertList []*RecordItemAlert, item RssFeedItem) []*RecordItemAlert {
+	alerts := []*RecordItemAlert{}
+	for _, alert := range alertList {
+		if alert.match(item) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// splitRssAlertItems takes the items matching any of alertList out of items.
+func splitRssAlertItems(alertList []*RecordItemAlert, items []RssFeedItem) ([]RssFeedItem, []RssFeedItem) {
+	if len(alertList) == 0 {
+		return items, nil
+	}
+
+	rest, alertItems := []RssFeedItem{}, []RssFeedItem{}
+	for _, item := range items {
+		if len(getMatchingAlerts(alertList, item)) > 0 {
+			alertItems = append(alertItems, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	return rest, alertItems
+}
+
+// sendRssAlerts sends each of items in a message of its own. It runs once the items are saved as seen,
+// so that a failed run never sends them twice.
+func sendRssAlerts(recordItem RecordItem, items []rssDigestItem) {
+	receiveId, receiveIdType := getRecordItemReceiver(recordItem)
+	for _, item := range items {
+		err := FeishuSendMessageText(receiveId, receiveIdType, getRssAlertText(item, getMatchingAlerts(recordItem.Settings.AlertList, item.RssFeedItem)))
+		if err != nil {
+			log.Println("error sending alert for record", recordItem.Id, item.Link, err)
+		}
+	}
+}
+
+func getRssAlertText(item rssDigestItem, alerts []*RecordItemAlert) string {
+	rules := []string{}
+	mentions := []string{}
+	for _, alert := range alerts {
+		rules = append(rules, alert.Rule)
+		if markdown := alert.GetMentionMarkdown(); markdown != "" {
+			mentions = append(mentions, markdown)
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("Alert (%s) from %s", strings.Join(rules, ", "), item.FeedTitle),
+		item.Title,
+		item.Link,
+	}
+	if len(mentions) > 0 {
+		lines = append(lines, strings.Join(mentions, " "))
+	}
+	return strings.Join(lines, "\n")
+}
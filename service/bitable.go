@@ -1,12 +1,15 @@
 package service
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/util"
 )
 
 type BitableRecordCondition struct {
@@ -23,6 +26,9 @@ type BitableRecordFilterInfo struct {
 type FeishuGetBitableRecordRequest struct {
 	ViewId string                  `json:"view_id"`
 	Filter BitableRecordFilterInfo `json:"filter"`
+	// query parameters
+	PageSize  int    `json:"-"`
+	PageToken string `json:"-"`
 }
 
 type FeishuGetBitableRecordItem struct {
@@ -45,52 +51,47 @@ type FeishuGetBitableRecordResponse struct {
 
 // https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/bitable-v1/app-table-record/search
 func FeishuGetBitableRecord(appToken string, tableId string, req FeishuGetBitableRecordRequest) (*FeishuGetBitableRecordResponse, error) {
-	url := fmt.Sprintf("https://open.feishu.cn/open-apis/bitable/v1/apps/%s/tables/%s/records/search", appToken, tableId)
-
-	// Convert the request to JSON
-	jsonData, err := json.Marshal(req)
-	fmt.Println("bitable get record request", string(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling request: %w", err)
+	query := neturl.Values{}
+	if req.PageSize > 0 {
+		query.Set("page_size", strconv.Itoa(req.PageSize))
 	}
-
-	// Create a new HTTP POST request
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+	if req.PageToken != "" {
+		query.Set("page_token", req.PageToken)
 	}
+	url := fmt.Sprintf("%s/open-apis/bitable/v1/apps/%s/tables/%s/records/search?%s", config.FeishuBaseUrl, appToken, tableId, query.Encode())
 
-	// Set headers
-	accessToken, err := GetAccessToken()
-	if err != nil {
-		return nil, fmt.Errorf("error getting access token: %w", err)
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
-	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Println("bitable get record request", string(util.Must(json.Marshal(req))))
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	var response FeishuGetBitableRecordResponse
+	body, err := feishuDo(http.MethodPost, url, req, true, &response)
+	fmt.Println("bitable get record response", string(body))
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// print response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-	fmt.Println("bitable get record response", string(body))
+	return &response, nil
+}
 
-	// Parse the response
-	var response FeishuGetBitableRecordResponse
-	err = json.Unmarshal(body, &response)
+type FeishuGetBitableRecordByIdResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		Record FeishuGetBitableRecordItem `json:"record"`
+	} `json:"data"`
+}
+
+// https://open.feishu.cn/document/server-docs/docs/bitable-v1/app-table-record/get
+func FeishuGetBitableRecordById(appToken string, tableId string, recordId string) (*FeishuGetBitableRecordItem, error) {
+	url := fmt.Sprintf("%s/open-apis/bitable/v1/apps/%s/tables/%s/records/%s", config.FeishuBaseUrl, appToken, tableId, recordId)
+
+	var response FeishuGetBitableRecordByIdResponse
+	body, err := feishuDo(http.MethodGet, url, nil, true, &response)
+	fmt.Println("bitable get record by id response", string(body))
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		return nil, err
 	}
 
-	return &response, nil
+	return &response.Data.Record, nil
 }
 
 type FeishuUpdateBitableRecordRequest struct {
@@ -100,45 +101,12 @@ type FeishuUpdateBitableRecordRequest struct {
 func FeishuUpdateBitableRecord(appToken string, tableId string, recordId string, req FeishuUpdateBitableRecordRequest) error {
 	log.Println("bitable update record", appToken, tableId, recordId, req)
 
-	url := fmt.Sprintf("https://open.feishu.cn/open-apis/bitable/v1/apps/%s/tables/%s/records/%s", appToken, tableId, recordId)
-
-	// Convert the request to JSON
-	jsonData, err := json.Marshal(req)
-	fmt.Println("bitable get record request", string(jsonData))
-	if err != nil {
-		return fmt.Errorf("error marshaling request: %w", err)
-	}
+	url := fmt.Sprintf("%s/open-apis/bitable/v1/apps/%s/tables/%s/records/%s", config.FeishuBaseUrl, appToken, tableId, recordId)
 
-	// Create a new HTTP PUT request
-	httpReq, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	accessToken, err := GetAccessToken()
-	if err != nil {
-		return fmt.Errorf("error getting access token: %w", err)
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
-	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// print response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
-	}
+	body, err := feishuDo(http.MethodPut, url, req, true, nil)
 	fmt.Println("bitable update record response", string(body))
 
-	return nil
+	return err
 }
 
 type FeishuAddBitableRecordRequest struct {
@@ -157,49 +125,13 @@ type FeishuAddBitableRecordResponse struct {
 
 func FeishuAddBitableRecord(appToken string, tableId string, req FeishuAddBitableRecordRequest) (string, error) {
 	log.Println("bitable add record", appToken, tableId, req)
-	url := fmt.Sprintf("https://open.feishu.cn/open-apis/bitable/v1/apps/%s/tables/%s/records", appToken, tableId)
-
-	// Convert the request to JSON
-	jsonData, err := json.Marshal(req)
-	fmt.Println("bitable add record request", string(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %w", err)
-	}
-
-	// Create a new HTTP POST request
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	accessToken, err := GetAccessToken()
-	if err != nil {
-		return "", fmt.Errorf("error getting access token: %w", err)
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
-	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// print response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
-	}
-	fmt.Println("bitable update record response", string(body))
+	url := fmt.Sprintf("%s/open-apis/bitable/v1/apps/%s/tables/%s/records", config.FeishuBaseUrl, appToken, tableId)
 
-	// get record id from response
 	var response FeishuAddBitableRecordResponse
-	err = json.Unmarshal(body, &response)
+	body, err := feishuDo(http.MethodPost, url, req, true, &response)
+	fmt.Println("bitable add record response", string(body))
 	if err != nil {
-		return "", fmt.Errorf("error unmarshaling response: %w", err)
+		return "", err
 	}
 
 	if response.Data.Record.RecordID == "" {
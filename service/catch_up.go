@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+// FeedCatchUpMode decides what happens to new items beyond the item limit of a feed,
+// e.g. when a busy feed published more items than the limit between two runs.
+type FeedCatchUpMode string
+
+const (
+	// drop them
+	FeedCatchUpOff FeedCatchUpMode = ""
+	// send them all, up to config.CatchUpItemLimit
+	FeedCatchUpAll FeedCatchUpMode = "all"
+	// add an "N more" item linking to the site
+	FeedCatchUpMore FeedCatchUpMode = "more"
+)
+
+func ParseFeedCatchUpMode(value string) (FeedCatchUpMode, error) {
+	switch mode := FeedCatchUpMode(value); mode {
+	case FeedCatchUpAll, FeedCatchUpMore:
+		return mode, nil
+	case "off":
+		return FeedCatchUpOff, nil
+	default:
+		return "", fmt.Errorf("unknown catch-up mode: %s", value)
+	}
+}
+
+func (mode FeedCatchUpMode) String() string {
+	if mode == FeedCatchUpOff {
+		return "off"
+	}
+	return string(mode)
+}
+
+// GetItemLimit returns how many items of the feed are sent per digest.
+func (feed *RecordItemFeed) GetItemLimit() int {
+	if feed.ItemLimit > 0 {
+		return feed.ItemLimit
+	}
+	return config.DefaultItemLimitPerFeed
+}
+
+// limitNewItems cuts the new items of a feed down to its limit, returning how many were held back
+// for an "N more" item.
+func (feed *RecordItemFeed) limitNewItems(items []RssFeedItem) ([]RssFeedItem, int) {
+	limit := feed.GetItemLimit()
+	if feed.CatchUp == FeedCatchUpAll {
+		limit = max(limit, config.CatchUpItemLimit)
+	}
+	if len(items) <= limit {
+		return items, 0
+	}
+
+	more := 0
+	if feed.CatchUp != FeedCatchUpOff {
+		more = len(items) - limit
+	}
+	return items[:limit], more
+}
+
+// getMoreDigestItem stands for the new items of a feed that were held back.
+func getMoreDigestItem(recordItemFeed *RecordItemFeed, feed *RssFeed, recordIndex int) rssDigestItem {
+	link := feed.Link
+	if link == "" {
+		link = recordItemFeed.Link
+	}
+	return rssDigestItem{
+		RssFeedItem: RssFeedItem{
+			Title: fmt.Sprintf("%d more", feed.MoreItemCount),
+			Link:  link,
+		},
+		FeedIndex: recordIndex,
+		FeedTitle: feed.Title,
+		FeedColor: util.GetColorByIndex(recordIndex),
+		More:      true,
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import "github.com/rhinoc/rss_feishu_bot/config"
+
+// DedupeRecordItemFeeds merges the feeds of recordItem that turn out to be the same, by the canonical form
+// of their link or by where they redirect to. The first one is kept, with its link as is,
+// along with the items delivered by all of them. It returns the links of the merged feeds.
+func DedupeRecordItemFeeds(recordItem *RecordItem) []string {
+	merged := []string{}
+
+	deduped := &RecordItem{}
+	for _, feed := range recordItem.FeedList {
+		kept := deduped.FindFeed(feed.Link)
+		if kept == nil && feed.ResolvedLink != "" {
+			kept = deduped.FindFeed(feed.ResolvedLink)
+		}
+		if kept != nil {
+			kept.SeenItemList = mergeSeenItemList(kept.SeenItemList, feed.SeenItemList)
+			merged = append(merged, feed.Link)
+			continue
+		}
+
+		deduped.FeedList = append(deduped.FeedList, feed)
+	}
+
+	recordItem.FeedList = deduped.FeedList
+	return merged
+}
+
+func mergeSeenItemList(seenItemList []string, other []string) []string {
+	result := make([]string, 0, len(seenItemList)+len(other))
+	seen := make(map[string]bool)
+	for _, key := range append(append([]string{}, seenItemList...), other...) {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+
+	if len(result) > config.SeenItemLimitPerFeed {
+		result = result[:config.SeenItemLimitPerFeed]
+	}
+	return result
+}
+
+type DedupeResult struct {
+	// records whose feed list changed
+	Updated int `json:"updated"`
+	// merged links by record id
+	Merged map[string][]string `json:"merged"`
+}
+
+// DedupeRecords runs DedupeRecordItemFeeds over every record of the store, saving those that changed.
+func DedupeRecords() (*DedupeResult, error) {
+	store := GetRecordStore()
+	result := &DedupeResult{Merged: make(map[string][]string)}
+
+	err := store.ForEachRecordItem(func(recordItem RecordItem) error {
+		merged := DedupeRecordItemFeeds(&recordItem)
+		if len(merged) == 0 {
+			return nil
+		}
+
+		if err := store.UpdateRecordItemFeedList(recordItem); err != nil {
+			return err
+		}
+		result.Updated++
+		result.Merged[recordItem.Id] = merged
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+// rssDigestFeedTag names another feed that carried a digest item.
+type rssDigestFeedTag struct {
+	Title string
+	Color string
+}
+
+// dedupeRssDigestItems keeps the first of the items sharing a canonical link, or a title across feeds,
+// e.g. an article carried by both its blog and an aggregator, and tags it with the other feeds.
+func dedupeRssDigestItems(items []rssDigestItem) []rssDigestItem {
+	result := []rssDigestItem{}
+	byLink := make(map[string]int)
+	byTitle := make(map[string]int)
+
+	for _, item := range items {
+		linkKey := ""
+		if item.Link != "" {
+			linkKey = util.UrlKey(item.Link)
+		}
+		titleKey := normalizeRssItemTitle(item.Title)
+
+		index, ok := byLink[linkKey]
+		if !ok {
+			index, ok = byTitle[titleKey]
+			// a feed may well repeat a title, e.g. "Weekly update"
+			ok = ok && titleKey != "" && result[index].FeedIndex != item.FeedIndex
+		}
+
+		if ok {
+			kept := &result[index]
+			if kept.FeedIndex != item.FeedIndex && !kept.hasFeedTag(item.FeedTitle) {
+				kept.OtherFeeds = append(kept.OtherFeeds, rssDigestFeedTag{Title: item.FeedTitle, Color: item.FeedColor})
+			}
+			if kept.ImageUrl == "" {
+				kept.ImageUrl = item.ImageUrl
+			}
+			continue
+		}
+
+		result = append(result, item)
+		if linkKey != "" {
+			byLink[linkKey] = len(result) - 1
+		}
+		if _, exists := byTitle[titleKey]; !exists && titleKey != "" {
+			byTitle[titleKey] = len(result) - 1
+		}
+	}
+
+	return result
+}
+
+func (item rssDigestItem) hasFeedTag(feedTitle string) bool {
+	if item.FeedTitle == feedTitle {
+		return true
+	}
+	for _, tag := range item.OtherFeeds {
+		if tag.Title == feedTitle {
+			return true
+		}
+	}
+	return false
+}
+
+// getTagsMarkdown renders the tags after the time of an item: the other feeds that carried it, then its podcast episode.
+func (item rssDigestItem) getTagsMarkdown() string {
+	markdown := ""
+	for _, tag := range item.OtherFeeds {
+		markdown += fmt.Sprintf("<text_tag color='%s'>%s</text_tag>", tag.Color, util.EscapeMarkdown(tag.Title))
+	}
+	return markdown + GetPodcastMarkdown(item.RssFeedItem)
+}
+
+// normalizeRssItemTitle lowercases a title and keeps only its letters and digits, split by single spaces.
+func normalizeRssItemTitle(title string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+var ErrNoFeedFound = errors.New("no feed found")
+
+var (
+	feedLinkTypes = []string{
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/feed+json",
+	}
+	commonFeedPaths = []string{
+		"/feed",
+		"/rss",
+		"/feed.xml",
+		"/rss.xml",
+		"/atom.xml",
+		"/index.xml",
+		"/feed.json",
+	}
+)
+
+type DiscoveredFeed struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+	// parsed feed, nil when the feed was only linked from a page and not fetched yet
+	Feed *RssFeed `json:"-"`
+}
+
+// DiscoverFeeds returns the feed at pageUrl itself or, when pageUrl is an HTML page, the feeds it
+// advertises through <link rel="alternate"> or serves at common feed paths.
+func DiscoverFeeds(pageUrl string) ([]DiscoveredFeed, error) {
+	resp, err := defaultRssFetcher.get(RssFetchRequest{Url: pageUrl})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("error fetching page: %w", gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		})
+	}
+
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(resp.Body))
+	if err == nil {
+		return []DiscoveredFeed{{Title: feed.Title, Link: pageUrl, Feed: newRssFeed(feed)}}, nil
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") && !isHtml(resp.Body) {
+		return nil, fmt.Errorf("error parsing rss feed: %w", err)
+	}
+
+	base, err := neturl.Parse(resp.Url)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing page url: %w", err)
+	}
+
+	feeds, err := discoverLinkedFeeds(base, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 {
+		feeds = probeCommonFeedPaths(base)
+	}
+	if len(feeds) == 0 {
+		return nil, ErrNoFeedFound
+	}
+
+	return feeds, nil
+}
+
+// ValidateFeed fetches and parses the feed, so that typos and dead links are never subscribed.
+func ValidateFeed(req RssFetchRequest) (*RssFetchResult, error) {
+	return FetchRssFeed(req)
+}
+
+// GetFetchErrorReason explains why a url could not be fetched or parsed as a feed.
+func GetFetchErrorReason(err error) string {
+	var httpErr gofeed.HTTPError
+	var netErr net.Error
+	var urlErr *neturl.Error
+	switch {
+	case errors.As(err, &httpErr):
+		return fmt.Sprintf("the server answered %s", httpErr.Status)
+	case errors.Is(err, gofeed.ErrFeedTypeNotDetected):
+		return "the URL is not an RSS, Atom or JSON feed"
+	case errors.Is(err, ErrNoFeedFound):
+		return "no feed was found on the page"
+	case errors.Is(err, ErrFeedAuthUnavailable):
+		return "the feed credentials could not be decrypted, please check FEED_SECRET_KEY or set them again"
+	case errors.Is(err, ErrSelectorNoMatch):
+		return "the selector matched no items on the page"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the server did not respond in time"
+	case errors.As(err, &urlErr):
+		return "the server could not be reached"
+	case strings.Contains(err.Error(), "error parsing rss feed"):
+		return "the feed could not be parsed"
+	default:
+		return err.Error()
+	}
+}
+
+func isHtml(body []byte) bool {
+	head := strings.ToLower(string(body[:min(len(body), 512)]))
+	return strings.Contains(head, "<!doctype html") || strings.Contains(head, "<html")
+}
+
+func discoverLinkedFeeds(base *neturl.URL, body []byte) ([]DiscoveredFeed, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html: %w", err)
+	}
+
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if baseHref, err := base.Parse(href); err == nil {
+			base = baseHref
+		}
+	}
+
+	feeds := []DiscoveredFeed{}
+	seen := make(map[string]bool)
+	doc.Find(`link[rel~="alternate"][href]`).Each(func(_ int, s *goquery.Selection) {
+		linkType := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+		if !isFeedLinkType(linkType) {
+			return
+		}
+
+		link, err := base.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil || seen[link.String()] {
+			return
+		}
+		seen[link.String()] = true
+
+		feeds = append(feeds, DiscoveredFeed{
+			Title: strings.TrimSpace(s.AttrOr("title", "")),
+			Link:  link.String(),
+		})
+	})
+
+	return feeds, nil
+}
+
+func isFeedLinkType(linkType string) bool {
+	for _, feedLinkType := range feedLinkTypes {
+		if linkType == feedLinkType {
+			return true
+		}
+	}
+	return false
+}
+
+func probeCommonFeedPaths(base *neturl.URL) []DiscoveredFeed {
+	candidates := make([]*DiscoveredFeed, len(commonFeedPaths))
+	// where each candidate ended up after redirects
+	finalUrls := make([]string, len(commonFeedPaths))
+	wg := sync.WaitGroup{}
+
+	for i, path := range commonFeedPaths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			link := base.ResolveReference(&neturl.URL{Path: path}).String()
+			result, err := defaultRssFetcher.Fetch(RssFetchRequest{Url: link})
+			if err != nil || result.StatusCode != http.StatusOK {
+				return
+			}
+			candidates[i] = &DiscoveredFeed{Title: result.Feed.Title, Link: link, Feed: result.Feed}
+			finalUrls[i] = result.Url
+		}()
+	}
+	wg.Wait()
+
+	// servers often answer several paths with the same feed, either redirecting to one url or serving it at each,
+	// keep the first of each; untitled feeds can only be told apart by url
+	feeds := []DiscoveredFeed{}
+	seenUrls := make(map[string]bool)
+	seenTitles := make(map[string]bool)
+	for i, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+		urlKey := util.UrlKey(finalUrls[i])
+		if seenUrls[urlKey] || candidate.Title != "" && seenTitles[candidate.Title] {
+			continue
+		}
+		seenUrls[urlKey] = true
+		seenTitles[candidate.Title] = true
+		feeds = append(feeds, *candidate)
+	}
+
+	return feeds
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"slices"
+	"testing"
+)
+
+func TestProbeCommonFeedPaths(t *testing.T) {
+	serveFeed := func(title string, item string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>%s</title><item><guid>%s</guid><title>%s</title></item></channel></rss>`, title, item, item)
+		}
+	}
+
+	mux := http.NewServeMux()
+	// untitled feeds, told apart by url
+	mux.HandleFunc("GET /feed", serveFeed("", "posts"))
+	mux.HandleFunc("GET /rss.xml", serveFeed("", "comments"))
+	mux.Handle("GET /rss", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	// the same titled feed served at two paths
+	mux.HandleFunc("GET /atom.xml", serveFeed("Blog", "atom"))
+	mux.HandleFunc("GET /index.xml", serveFeed("Blog", "index"))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	base, err := neturl.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := []string{}
+	for _, feed := range probeCommonFeedPaths(base) {
+		links = append(links, feed.Link)
+	}
+	want := []string{server.URL + "/feed", server.URL + "/rss.xml", server.URL + "/atom.xml"}
+	if !slices.Equal(links, want) {
+		t.Errorf("links = %v, want %v", links, want)
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+var (
+	ErrFeedSecretKeyMissing = errors.New("FEED_SECRET_KEY is not configured")
+	ErrFeedAuthUnavailable  = errors.New("the feed credentials could not be decrypted")
+)
+
+const feedAuthSealPrefix = "v1:"
+
+// RecordItemFeedAuth holds the credentials sent with every request for a feed.
+// It is always encrypted when serialized, so neither store ever holds it in plain text.
+type RecordItemFeedAuth struct {
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Cookie   string            `json:"cookie,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	// ciphertext that couldn't be decrypted, e.g. after the key changed, kept so saving doesn't drop it
+	sealed string
+}
+
+type recordItemFeedAuthPlain RecordItemFeedAuth
+
+func (auth RecordItemFeedAuth) MarshalJSON() ([]byte, error) {
+	if auth.sealed != "" {
+		return json.Marshal(auth.sealed)
+	}
+
+	plain, err := json.Marshal(recordItemFeedAuthPlain(auth))
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealFeedAuth(plain)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+func (auth *RecordItemFeedAuth) UnmarshalJSON(data []byte) error {
+	var sealed string
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return err
+	}
+
+	plain, err := openFeedAuth(sealed)
+	if err != nil {
+		// a wrong key must not make the whole record unreadable
+		log.Println("error decrypting feed credentials", err)
+		*auth = RecordItemFeedAuth{sealed: sealed}
+		return nil
+	}
+
+	var value recordItemFeedAuthPlain
+	if err := json.Unmarshal(plain, &value); err != nil {
+		return err
+	}
+	*auth = RecordItemFeedAuth(value)
+	return nil
+}
+
+// IsEmpty reports whether there is nothing to send.
+func (auth *RecordItemFeedAuth) IsEmpty() bool {
+	return auth == nil || auth.sealed == "" && auth.Username == "" && auth.Password == "" &&
+		auth.Token == "" && auth.Cookie == "" && len(auth.Headers) == 0
+}
+
+// IsUnreadable reports credentials that couldn't be decrypted with the current key.
+func (auth *RecordItemFeedAuth) IsUnreadable() bool {
+	return auth != nil && auth.sealed != ""
+}
+
+// Describe lists which kinds of credentials are set, without their values.
+func (auth *RecordItemFeedAuth) Describe() string {
+	if auth.IsEmpty() {
+		return "none"
+	}
+	if auth.IsUnreadable() {
+		return "unreadable"
+	}
+
+	kinds := []string{}
+	if auth.Username != "" || auth.Password != "" {
+		kinds = append(kinds, "basic")
+	}
+	if auth.Token != "" {
+		kinds = append(kinds, "bearer")
+	}
+	if auth.Cookie != "" {
+		kinds = append(kinds, "cookie")
+	}
+	names := make([]string, 0, len(auth.Headers))
+	for name := range auth.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		kinds = append(kinds, "header "+name)
+	}
+	return strings.Join(kinds, ", ")
+}
+
+type feedAuthHeadersKey struct{}
+
+// apply sets the credentials on httpReq, and returns it carrying the names of the headers they went in,
+// so that checkFeedAuthRedirect keeps them from other hosts.
+func (auth *RecordItemFeedAuth) apply(httpReq *http.Request) (*http.Request, error) {
+	if auth.IsEmpty() {
+		return httpReq, nil
+	}
+	if auth.IsUnreadable() {
+		return nil, ErrFeedAuthUnavailable
+	}
+
+	names := []string{}
+	for name, value := range auth.Headers {
+		httpReq.Header.Set(name, value)
+		names = append(names, name)
+	}
+	if auth.Username != "" || auth.Password != "" {
+		httpReq.SetBasicAuth(auth.Username, auth.Password)
+		names = append(names, "Authorization")
+	}
+	if auth.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+auth.Token)
+		names = append(names, "Authorization")
+	}
+	if auth.Cookie != "" {
+		httpReq.Header.Set("Cookie", auth.Cookie)
+		names = append(names, "Cookie")
+	}
+	return httpReq.WithContext(context.WithValue(httpReq.Context(), feedAuthHeadersKey{}, names)), nil
+}
+
+// checkFeedAuthRedirect drops the credentials from a redirect to another host, e.g. a CDN or a login page.
+// net/http only drops Authorization and Cookie, not custom headers such as PRIVATE-TOKEN.
+func checkFeedAuthRedirect(req *http.Request, via []*http.Request) error {
+	// the default policy of net/http
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	names, _ := req.Context().Value(feedAuthHeadersKey{}).([]string)
+	if req.URL.Host != via[0].URL.Host {
+		for _, name := range names {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// fingerprint tells apart requests made with different credentials, without revealing them.
+func (auth *RecordItemFeedAuth) fingerprint() string {
+	if auth.IsEmpty() {
+		return ""
+	}
+	if auth.sealed != "" {
+		return auth.sealed
+	}
+	plain := util.Must(json.Marshal(recordItemFeedAuthPlain(*auth)))
+	sum := sha256.Sum256(plain)
+	return hex.EncodeToString(sum[:])
+}
+
+func getFeedAuthCipher() (cipher.AEAD, error) {
+	if config.FeedSecretKey == "" {
+		return nil, ErrFeedSecretKeyMissing
+	}
+
+	key := sha256.Sum256([]byte(config.FeedSecretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealFeedAuth(plain []byte) (string, error) {
+	aead, err := getFeedAuthCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+	return feedAuthSealPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openFeedAuth(sealed string) ([]byte, error) {
+	aead, err := getFeedAuthCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, feedAuthSealPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
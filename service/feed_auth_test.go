@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeedAuthHeadersStayOnHost(t *testing.T) {
+	received := make(map[string]http.Header)
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			received[name] = r.Header.Clone()
+			w.Write([]byte("ok"))
+		}
+	}
+
+	other := httptest.NewServer(record("other"))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/same", record("same"))
+	mux.HandleFunc("/to-same", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/same", http.StatusFound)
+	})
+	mux.HandleFunc("/to-other", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/feed", http.StatusFound)
+	})
+	origin := httptest.NewServer(mux)
+	defer origin.Close()
+
+	fetcher := NewRssFetcher(1, 1, 5*time.Second, 0, 0)
+	auth := &RecordItemFeedAuth{Token: "token", Headers: map[string]string{"Private-Token": "secret"}}
+
+	if _, err := fetcher.get(RssFetchRequest{Url: origin.URL + "/to-same", Auth: auth}); err != nil {
+		t.Fatal(err)
+	}
+	if got := received["same"].Get("Private-Token"); got != "secret" {
+		t.Errorf("same host: Private-Token = %q, want secret", got)
+	}
+
+	if _, err := fetcher.get(RssFetchRequest{Url: origin.URL + "/to-other", Auth: auth}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Private-Token", "Authorization"} {
+		if got := received["other"].Get(name); got != "" {
+			t.Errorf("other host: %s = %q, want none", name, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrFeishuPermissionDenied = errors.New("feishu permission denied")
+	ErrFeishuRateLimited      = errors.New("feishu rate limited")
+	ErrFeishuNotFound         = errors.New("feishu resource not found")
+)
+
+// https://open.feishu.cn/document/server-docs/getting-started/server-error-codes
+var (
+	feishuPermissionDeniedCodes = map[int]bool{
+		99991672: true, // app scope not enabled
+		99991679: true, // user scope not granted
+		1254302:  true, // bitable: no permission
+		91403:    true, // forbidden
+		230002:   true, // bot is not in the chat
+		230013:   true, // bot has no availability for the user
+		230027:   true, // lack of permission
+	}
+	feishuRateLimitedCodes = map[int]bool{
+		99991400: true, // request frequency limit
+		1254290:  true, // bitable: too many requests
+		230020:   true, // message frequency limit
+	}
+	feishuNotFoundCodes = map[int]bool{
+		1254040: true, // bitable: app token not found
+		1254041: true, // bitable: table not found
+		1254042: true, // bitable: view not found
+		1254043: true, // bitable: record not found
+		1254044: true, // bitable: field not found
+		234003:  true, // message resource not found
+	}
+)
+
+// FeishuError is returned for any non-zero `code` in a Feishu open API response.
+type FeishuError struct {
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+	LogId      string `json:"log_id"`
+	HttpStatus int    `json:"http_status"`
+}
+
+func (e *FeishuError) Error() string {
+	return fmt.Sprintf("feishu api error: code=%d msg=%s log_id=%s", e.Code, e.Msg, e.LogId)
+}
+
+func (e *FeishuError) Is(target error) bool {
+	switch target {
+	case ErrFeishuPermissionDenied:
+		return feishuPermissionDeniedCodes[e.Code] || e.HttpStatus == http.StatusForbidden
+	case ErrFeishuRateLimited:
+		return feishuRateLimitedCodes[e.Code] || e.HttpStatus == http.StatusTooManyRequests
+	case ErrFeishuNotFound:
+		return feishuNotFoundCodes[e.Code] || e.HttpStatus == http.StatusNotFound
+	}
+	return false
+}
+
+type feishuBaseResponse struct {
+	Code  int    `json:"code"`
+	Msg   string `json:"msg"`
+	Error struct {
+		LogId string `json:"log_id"`
+	} `json:"error"`
+}
+
+// feishuDo sends a JSON request to the Feishu open API and unmarshals the response into resp,
+// or stores the raw body when resp is a *[]byte. A non-zero `code` is returned as a *FeishuError.
+func feishuDo(method string, url string, req interface{}, withAuth bool, resp interface{}) ([]byte, error) {
+	var reqBody io.Reader
+	if req != nil {
+		jsonData, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling request: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	httpReq, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	return feishuDoRequest(httpReq, withAuth, resp)
+}
+
+func feishuDoRequest(httpReq *http.Request, withAuth bool, resp interface{}) ([]byte, error) {
+	// Set headers
+	if withAuth {
+		accessToken, err := GetAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("error getting access token: %w", err)
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	// Send the request
+	client := &http.Client{}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	// downloads answer with the file itself, and only errors as JSON
+	raw, isRaw := resp.(*[]byte)
+
+	var base feishuBaseResponse
+	err = json.Unmarshal(body, &base)
+	if err != nil {
+		if httpResp.StatusCode != http.StatusOK {
+			return body, &FeishuError{
+				Code:       -1,
+				Msg:        http.StatusText(httpResp.StatusCode),
+				LogId:      httpResp.Header.Get("X-Tt-Logid"),
+				HttpStatus: httpResp.StatusCode,
+			}
+		}
+		if isRaw {
+			*raw = body
+			return body, nil
+		}
+		return body, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+
+	if base.Code != 0 || httpResp.StatusCode >= http.StatusBadRequest {
+		logId := base.Error.LogId
+		if logId == "" {
+			logId = httpResp.Header.Get("X-Tt-Logid")
+		}
+		return body, &FeishuError{
+			Code:       base.Code,
+			Msg:        base.Msg,
+			LogId:      logId,
+			HttpStatus: httpResp.StatusCode,
+		}
+	}
+
+	if isRaw {
+		*raw = body
+	} else if resp != nil {
+		err = json.Unmarshal(body, resp)
+		if err != nil {
+			return body, fmt.Errorf("error unmarshaling response: %w", err)
+		}
+	}
+
+	return body, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+)
+
+// a 1x1 png
+var testPng = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\x9cc\xf8\x0f\x00\x00\x01\x01\x00\x05\x18\xd8N\x00\x00\x00\x00IEND\xaeB`\x82")
+
+// feishuStandIn answers the Feishu open apis the bot calls, and serves images under /images/.
+type feishuStandIn struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// file names of the images uploaded
+	uploads []string
+	// upload attempts, failed ones included
+	uploadAttempts int
+	// uploads to fail before succeeding, e.g. as if rate limited
+	uploadFailures int
+	messages       []FeishuSendMessageRequest
+}
+
+// newFeishuStandIn points config.FeishuBaseUrl at a stand-in until the test ends.
+func newFeishuStandIn(t *testing.T) *feishuStandIn {
+	standIn := &feishuStandIn{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /open-apis/auth/v3/tenant_access_token/internal", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":0,"msg":"ok","tenant_access_token":"t-test","expire":7200}`)
+	})
+	mux.HandleFunc("POST /open-apis/im/v1/images", standIn.handleUploadImage)
+	mux.HandleFunc("POST /open-apis/im/v1/messages", standIn.handleSendMessage)
+	mux.HandleFunc("GET /images/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.PathValue("name"), "missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPng)
+	})
+	standIn.Server = httptest.NewServer(mux)
+
+	baseUrl := config.FeishuBaseUrl
+	config.FeishuBaseUrl = standIn.URL
+	cachedToken, tokenExpiry = "", time.Time{}
+	t.Cleanup(func() {
+		standIn.Close()
+		config.FeishuBaseUrl = baseUrl
+		cachedToken, tokenExpiry = "", time.Time{}
+	})
+
+	return standIn
+}
+
+func (s *feishuStandIn) handleUploadImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadAttempts++
+
+	if r.Header.Get("Authorization") != "Bearer t-test" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":99991661,"msg":"missing access token"}`)
+		return
+	}
+	if s.uploadFailures > 0 {
+		s.uploadFailures--
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":99991400,"msg":"request trigger frequency limit"}`)
+		return
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil || r.FormValue("image_type") != "message" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":234001,"msg":"invalid request"}`)
+		return
+	}
+	file.Close()
+
+	// named after the file, as uploads run in parallel
+	s.uploads = append(s.uploads, header.Filename)
+	fmt.Fprintf(w, `{"code":0,"msg":"success","data":{"image_key":"img_%s"}}`, strings.TrimSuffix(header.Filename, path.Ext(header.Filename)))
+}
+
+func (s *feishuStandIn) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	var req FeishuSendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":230001,"msg":"invalid request"}`)
+		return
+	}
+	req.ReceiveIdType = r.URL.Query().Get("receive_id_type")
+
+	s.mu.Lock()
+	s.messages = append(s.messages, req)
+	s.mu.Unlock()
+
+	fmt.Fprint(w, `{"code":0,"msg":"success","data":{}}`)
+}
+
+func (s *feishuStandIn) imageUrl(name string) string {
+	return s.URL + "/images/" + name
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rhinoc/rss_feishu_bot/config"
+)
+
+type RssFetchRequest struct {
+	Url string
+	// validators of the previous response, sent as If-None-Match / If-Modified-Since
+	ETag         string
+	LastModified string
+	// extract items from an HTML page instead of parsing a feed
+	Selector *RecordItemFeedSelector
+	// credentials and headers of the subscriber
+	Auth *RecordItemFeedAuth
+}
+
+// resourceKey identifies what is fetched, regardless of validators.
+func (req RssFetchRequest) resourceKey() string {
+	parts := []string{req.Url}
+	if req.Selector != nil {
+		parts = append(parts, req.Selector.Item, req.Selector.Title, req.Selector.Link)
+	}
+	// a private feed may differ per account
+	if !req.Auth.IsEmpty() {
+		parts = append(parts, req.Auth.fingerprint())
+	}
+	return strings.Join(parts, "\n")
+}
+
+type RssFetchResult struct {
+	// final url after redirects
+	Url          string
+	Feed         *RssFeed
+	NotModified  bool
+	ETag         string
+	LastModified string
+	StatusCode   int
+}
+
+type rssFetchResponse struct {
+	// final url after redirects
+	Url        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// RssFetcher fetches feeds under a global and a per-host concurrency limit,
+// retrying transient failures with exponential backoff.
+type RssFetcher struct {
+	client          *http.Client
+	slots           chan struct{}
+	hostConcurrency int
+	retries         int
+	retryBackoff    time.Duration
+
+	mu        sync.Mutex
+	hostSlots map[string]chan struct{}
+}
+
+func NewRssFetcher(concurrency int, hostConcurrency int, timeout time.Duration, retries int, retryBackoff time.Duration) *RssFetcher {
+	return &RssFetcher{
+		client:          &http.Client{Timeout: timeout, CheckRedirect: checkFeedAuthRedirect},
+		slots:           make(chan struct{}, max(concurrency, 1)),
+		hostConcurrency: max(hostConcurrency, 1),
+		retries:         max(retries, 0),
+		retryBackoff:    retryBackoff,
+		hostSlots:       make(map[string]chan struct{}),
+	}
+}
+
+var defaultRssFetcher = NewRssFetcher(
+	config.FetchConcurrency,
+	config.FetchHostConcurrency,
+	config.FetchTimeout,
+	config.FetchRetries,
+	config.FetchRetryBackoff,
+)
+
+// FetchRssFeed fetches a feed url, or a source uri through its SourceAdapter.
+func FetchRssFeed(req RssFetchRequest) (*RssFetchResult, error) {
+	if adapter := GetSourceAdapter(req.Url); adapter != nil {
+		return fetchSource(adapter, req)
+	}
+	return defaultRssFetcher.Fetch(req)
+}
+
+func (f *RssFetcher) Fetch(req RssFetchRequest) (*RssFetchResult, error) {
+	resp, err := f.get(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RssFetchResult{
+		Url:          resp.Url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		StatusCode:   resp.StatusCode,
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		// keep the previous validators if the server didn't repeat them
+		if result.ETag == "" {
+			result.ETag = req.ETag
+		}
+		if result.LastModified == "" {
+			result.LastModified = req.LastModified
+		}
+		result.NotModified = true
+		result.Feed = &RssFeed{Link: req.Url, Items: []RssFeedItem{}}
+		return result, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("error fetching rss feed: %w", gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		})
+	}
+
+	if req.Selector != nil {
+		result.Feed, err = extractHtmlFeed(resp.Url, resp.Body, req.Selector)
+		if err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(resp.Body))
+	if err != nil {
+		return result, fmt.Errorf("error parsing rss feed: %w", err)
+	}
+	result.Feed = newRssFeed(feed)
+
+	return result, nil
+}
+
+func (f *RssFetcher) get(req RssFetchRequest) (*rssFetchResponse, error) {
+	// not worth retrying
+	if req.Auth.IsUnreadable() {
+		return nil, ErrFeedAuthUnavailable
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := f.getOnce(req)
+		if attempt >= f.retries || !isTransientFetchFailure(resp, err) {
+			return resp, err
+		}
+
+		backoff := f.retryBackoff << attempt
+		log.Println("retrying rss feed", req.Url, "in", backoff, "after", getFetchFailureReason(resp, err))
+		time.Sleep(backoff)
+	}
+}
+
+func (f *RssFetcher) getOnce(req RssFetchRequest) (*rssFetchResponse, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, req.Url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("User-Agent", "Gofeed/1.0")
+	httpReq, err = req.Auth.apply(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if req.ETag != "" {
+		httpReq.Header.Set("If-None-Match", req.ETag)
+	}
+	if req.LastModified != "" {
+		httpReq.Header.Set("If-Modified-Since", req.LastModified)
+	}
+
+	release := f.acquire(httpReq.URL.Host)
+	defer release()
+
+	resp, err := f.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching rss feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading rss feed: %w", err)
+	}
+
+	return &rssFetchResponse{
+		Url:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+const maxFetchBodySize = 10 << 20
+
+func (f *RssFetcher) acquire(host string) func() {
+	f.mu.Lock()
+	hostSlots, ok := f.hostSlots[host]
+	if !ok {
+		hostSlots = make(chan struct{}, f.hostConcurrency)
+		f.hostSlots[host] = hostSlots
+	}
+	f.mu.Unlock()
+
+	hostSlots <- struct{}{}
+	f.slots <- struct{}{}
+
+	return func() {
+		<-f.slots
+		<-hostSlots
+	}
+}
+
+// isTransientFetchFailure reports timeouts, temporary network errors, 429 and 5xx, which are worth retrying.
+// Anything else, e.g. a bad url, an unknown host or a certificate error, would fail the same way again.
+func isTransientFetchFailure(resp *rssFetchResponse, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr):
+			return dnsErr.IsTimeout || dnsErr.IsTemporary
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return true
+		}
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func getFetchFailureReason(resp *rssFetchResponse, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// RssFetchCache shares fetch results within one send run, so each feed url is fetched and parsed
+// once and then fanned out to every subscriber. A nil cache fetches directly.
+type RssFetchCache struct {
+	mu      sync.Mutex
+	entries map[string]*rssFetchCacheEntry
+}
+
+type rssFetchCacheEntry struct {
+	done   chan struct{}
+	result *RssFetchResult
+	err    error
+}
+
+func NewRssFetchCache() *RssFetchCache {
+	return &RssFetchCache{
+		entries: make(map[string]*rssFetchCacheEntry),
+	}
+}
+
+func (c *RssFetchCache) Fetch(req RssFetchRequest) (*RssFetchResult, error) {
+	if c == nil {
+		return FetchRssFeed(req)
+	}
+
+	// a full response for the url satisfies every subscriber, whatever validators they hold,
+	// while a 304 only answers subscribers holding the same validators
+	resourceKey := req.resourceKey()
+	key := resourceKey
+	if req.ETag != "" || req.LastModified != "" {
+		key = resourceKey + "\n" + req.ETag + "\n" + req.LastModified
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[resourceKey]; ok {
+		c.mu.Unlock()
+		<-entry.done
+		if entry.err != nil || !entry.result.NotModified {
+			return copyRssFetchResult(entry.result), entry.err
+		}
+		c.mu.Lock()
+	}
+	if entry, ok := c.entries[key]; ok {
+		c.mu.Unlock()
+		<-entry.done
+		return copyRssFetchResult(entry.result), entry.err
+	}
+	entry := &rssFetchCacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	entry.result, entry.err = FetchRssFeed(req)
+	close(entry.done)
+
+	if key != resourceKey && entry.err == nil && !entry.result.NotModified {
+		c.mu.Lock()
+		if _, ok := c.entries[resourceKey]; !ok {
+			c.entries[resourceKey] = entry
+		}
+		c.mu.Unlock()
+	}
+
+	return copyRssFetchResult(entry.result), entry.err
+}
+
+// copyRssFetchResult lets each subscriber trim and filter its items without touching the shared result.
+func copyRssFetchResult(result *RssFetchResult) *RssFetchResult {
+	if result == nil {
+		return nil
+	}
+
+	copied := *result
+	if result.Feed != nil {
+		feed := *result.Feed
+		feed.Items = append([]RssFeedItem{}, result.Feed.Items...)
+		copied.Feed = &feed
+	}
+
+	return &copied
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	neturl "net/url"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+type FeishuUploadFileResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		FileKey string `json:"file_key"`
+	} `json:"data"`
+}
+
+// https://open.feishu.cn/document/server-docs/im-v1/file/create
+func FeishuUploadFile(fileName string, file []byte) (string, error) {
+	url := fmt.Sprintf("%s/open-apis/im/v1/files", config.FeishuBaseUrl)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("file_type", "stream")
+	writer.WriteField("file_name", fileName)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", fmt.Errorf("error creating form file: %w", err)
+	}
+	part.Write(file)
+	writer.Close()
+
+	httpReq, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var response FeishuUploadFileResponse
+	respBody, err := feishuDoRequest(httpReq, true, &response)
+	fmt.Println("upload file response", string(respBody))
+	if err != nil {
+		return "", err
+	}
+
+	return response.Data.FileKey, nil
+}
+
+func FeishuSendMessageFile(receiveId, receiveIdType, fileKey string) error {
+	type FileContent struct {
+		FileKey string `json:"file_key"`
+	}
+
+	return FeishuSendMessage(FeishuSendMessageRequest{
+		ReceiveId:     receiveId,
+		ReceiveIdType: receiveIdType,
+		MsgType:       "file",
+		Content:       string(util.Must(json.Marshal(FileContent{FileKey: fileKey}))),
+	})
+}
+
+// FeishuGetMessageResource downloads a file attached to a message the bot received.
+// https://open.feishu.cn/document/server-docs/im-v1/message/get-2
+func FeishuGetMessageResource(messageId, fileKey string) ([]byte, error) {
+	url := fmt.Sprintf("%s/open-apis/im/v1/messages/%s/resources/%s?type=file",
+		config.FeishuBaseUrl, neturl.PathEscape(messageId), neturl.PathEscape(fileKey))
+
+	var file []byte
+	_, err := feishuDo(http.MethodGet, url, nil, true, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
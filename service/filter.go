@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// fields a filter rule can be scoped to, e.g. "-author:bot"
+var feedFilterFields = []string{"title", "description", "author", "category"}
+
+// FeedFilterRule is one rule of /filter: "+word" or "word" keeps only matching items, "-word" drops them.
+// The word may be a /regex/ and may be scoped to a field, e.g. "+title:/k8s|kubernetes/".
+type FeedFilterRule struct {
+	Exclude bool
+	// one of feedFilterFields, or empty for any
+	Field   string
+	keyword string
+	regex   *regexp.Regexp
+}
+
+func ParseFeedFilterRule(arg string) (*FeedFilterRule, error) {
+	rule := &FeedFilterRule{}
+	switch {
+	case strings.HasPrefix(arg, "+"):
+		arg = arg[1:]
+	case strings.HasPrefix(arg, "-"):
+		rule.Exclude = true
+		arg = arg[1:]
+	}
+	arg, rule.Field = cutFeedFilterField(arg)
+
+	if len(arg) > 2 && strings.HasPrefix(arg, "/") && strings.HasSuffix(arg, "/") {
+		regex, err := regexp.Compile("(?i)" + arg[1:len(arg)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", arg, err)
+		}
+		rule.regex = regex
+	} else {
+		rule.keyword = strings.ToLower(arg)
+	}
+	if rule.regex == nil && rule.keyword == "" {
+		return nil, fmt.Errorf("empty filter rule")
+	}
+
+	return rule, nil
+}
+
+func cutFeedFilterField(arg string) (string, string) {
+	for _, field := range feedFilterFields {
+		if rest, ok := strings.CutPrefix(arg, field+":"); ok {
+			return rest, field
+		}
+	}
+	return arg, ""
+}
+
+func (rule *FeedFilterRule) match(item RssFeedItem) bool {
+	values := []string{}
+	if rule.Field == "" || rule.Field == "title" {
+		values = append(values, item.Title)
+	}
+	if rule.Field == "" || rule.Field == "description" {
+		values = append(values, item.Summary)
+	}
+	if rule.Field == "" || rule.Field == "author" {
+		values = append(values, item.Author)
+	}
+	if rule.Field == "" || rule.Field == "category" {
+		values = append(values, item.Categories...)
+	}
+
+	for _, value := range values {
+		if rule.regex != nil && rule.regex.MatchString(value) ||
+			rule.regex == nil && strings.Contains(strings.ToLower(value), rule.keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRssFeedItems keeps the items matching any include rule, if there is one, and no exclude rule.
+func filterRssFeedItems(items []RssFeedItem, filters []string) []RssFeedItem {
+	if len(filters) == 0 {
+		return items
+	}
+
+	rules := []*FeedFilterRule{}
+	hasInclude := false
+	for _, filter := range filters {
+		rule, err := ParseFeedFilterRule(filter)
+		if err != nil {
+			log.Println("error parsing feed filter", filter, err)
+			continue
+		}
+		rules = append(rules, rule)
+		hasInclude = hasInclude || !rule.Exclude
+	}
+
+	result := []RssFeedItem{}
+	for _, item := range items {
+		included := !hasInclude
+		excluded := false
+		for _, rule := range rules {
+			if !rule.match(item) {
+				continue
+			}
+			if rule.Exclude {
+				excluded = true
+				break
+			}
+			included = true
+		}
+		if included && !excluded {
+			result = append(result, item)
+		}
+	}
+	return result
+}
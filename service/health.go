@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+const maxFeedErrorLength = 200
+
+type FeedHealthStatus string
+
+const (
+	FeedHealthPending   FeedHealthStatus = "pending"
+	FeedHealthHealthy   FeedHealthStatus = "healthy"
+	FeedHealthFailing   FeedHealthStatus = "failing"
+	FeedHealthSuspended FeedHealthStatus = "suspended"
+)
+
+func (feed *RecordItemFeed) HealthStatus() FeedHealthStatus {
+	switch {
+	case feed.Suspended:
+		return FeedHealthSuspended
+	case feed.FailureCount > 0:
+		return FeedHealthFailing
+	case feed.LastSuccessAt != nil:
+		return FeedHealthHealthy
+	default:
+		return FeedHealthPending
+	}
+}
+
+func (feed *RecordItemFeed) recordSuccess() {
+	now := time.Now()
+	feed.LastSuccessAt = &now
+	feed.FailureCount = 0
+}
+
+// recordFailure reports whether this failure just crossed config.FeedFailureThreshold and suspended the feed.
+func (feed *RecordItemFeed) recordFailure(err error) bool {
+	now := time.Now()
+	feed.LastErrorAt = &now
+	feed.LastError = err.Error()
+	if len(feed.LastError) > maxFeedErrorLength {
+		feed.LastError = feed.LastError[:maxFeedErrorLength] + "..."
+	}
+	feed.FailureCount++
+
+	if !feed.Suspended && config.FeedFailureThreshold > 0 && feed.FailureCount >= config.FeedFailureThreshold {
+		feed.Suspended = true
+		return true
+	}
+	return false
+}
+
+// Resume clears the failure state of a suspended feed so it is fetched again.
+func (feed *RecordItemFeed) Resume() {
+	feed.Suspended = false
+	feed.FailureCount = 0
+}
+
+// restoreReadState undoes what a run read from the feed, keeping the health state it recorded.
+func (feed *RecordItemFeed) restoreReadState(previous RecordItemFeed) {
+	feed.LastReadLink = previous.LastReadLink
+	feed.SeenItemList = previous.SeenItemList
+	feed.ETag = previous.ETag
+	feed.LastModified = previous.LastModified
+}
+
+// getFeedStateDigest ignores LastSuccessAt, so a healthy feed with nothing new doesn't cost a write every run,
+// and Auth, which is encrypted with a fresh nonce each time and isn't written by a run anyway.
+func getFeedStateDigest(feedList []*RecordItemFeed) string {
+	feeds := make([]RecordItemFeed, 0, len(feedList))
+	for _, feed := range feedList {
+		copied := *feed
+		copied.LastSuccessAt = nil
+		copied.Auth = nil
+		feeds = append(feeds, copied)
+	}
+	return string(util.Must(json.Marshal(feeds)))
+}
+
+func notifyFeedSuspended(recordItem RecordItem, feed *RecordItemFeed) {
+	receiveId, receiveIdType := getRecordItemReceiver(recordItem)
+	err := FeishuSendMessageText(receiveId, receiveIdType, fmt.Sprintf(
+		"Subscription suspended after %d consecutive failures: %s\nLast error: %s\nSend /add %s to resume it.",
+		feed.FailureCount, feed.Link, feed.LastError, feed.Link,
+	))
+	if err != nil {
+		log.Println("error notifying suspended feed", feed.Link, err)
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/rhinoc/rss_feishu_bot/config"
+)
+
+type FeishuUploadImageResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		ImageKey string `json:"image_key"`
+	} `json:"data"`
+}
+
+// https://open.feishu.cn/document/server-docs/im-v1/image/create
+func FeishuUploadImage(fileName string, image []byte) (string, error) {
+	url := fmt.Sprintf("%s/open-apis/im/v1/images", config.FeishuBaseUrl)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("image_type", "message")
+	part, err := writer.CreateFormFile("image", fileName)
+	if err != nil {
+		return "", fmt.Errorf("error creating form file: %w", err)
+	}
+	part.Write(image)
+	writer.Close()
+
+	httpReq, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var response FeishuUploadImageResponse
+	respBody, err := feishuDoRequest(httpReq, true, &response)
+	fmt.Println("upload image response", string(respBody))
+	if err != nil {
+		return "", err
+	}
+
+	return response.Data.ImageKey, nil
+}
+
+// ImageUploader turns an image url into a Feishu image_key.
+// Swap it with SetImageUploader to run against a stand-in instead of Feishu.
+type ImageUploader interface {
+	UploadImage(imageUrl string) (string, error)
+}
+
+type feishuImageUploader struct{}
+
+func (feishuImageUploader) UploadImage(imageUrl string) (string, error) {
+	resp, err := defaultRssFetcher.get(RssFetchRequest{Url: imageUrl})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching image: %w", gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		})
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("error fetching image: unexpected content type %s", contentType)
+	}
+
+	fileName := "image"
+	if parsed, err := neturl.Parse(imageUrl); err == nil && path.Base(parsed.Path) != "/" {
+		fileName = path.Base(parsed.Path)
+	}
+
+	return FeishuUploadImage(fileName, resp.Body)
+}
+
+const maxImageKeyCacheSize = 10000
+
+// cachedImageUploader remembers the image_key of every image url, so each image is uploaded once.
+// Failed uploads are tried again, as they may well be a timeout or a rate limit.
+type cachedImageUploader struct {
+	uploader ImageUploader
+	mu       sync.Mutex
+	keys     map[string]string
+}
+
+func NewCachedImageUploader(uploader ImageUploader) ImageUploader {
+	return &cachedImageUploader{
+		uploader: uploader,
+		keys:     make(map[string]string),
+	}
+}
+
+func (u *cachedImageUploader) UploadImage(imageUrl string) (string, error) {
+	u.mu.Lock()
+	imageKey, ok := u.keys[imageUrl]
+	u.mu.Unlock()
+	if ok {
+		return imageKey, nil
+	}
+
+	imageKey, err := u.uploader.UploadImage(imageUrl)
+	if err != nil || imageKey == "" {
+		return imageKey, err
+	}
+
+	u.mu.Lock()
+	if len(u.keys) >= maxImageKeyCacheSize {
+		u.keys = make(map[string]string)
+	}
+	u.keys[imageUrl] = imageKey
+	u.mu.Unlock()
+
+	return imageKey, nil
+}
+
+var imageUploader = NewCachedImageUploader(feishuImageUploader{})
+
+func SetImageUploader(uploader ImageUploader) {
+	imageUploader = uploader
+}
+
+// getRssFeedItemImage picks a representative image: the item image, a media thumbnail,
+// an image enclosure, then the first image in the item html.
+func getRssFeedItemImage(item *gofeed.Item) string {
+	image := ""
+	switch {
+	case item.Image != nil && item.Image.URL != "":
+		image = item.Image.URL
+	case getMediaThumbnail(item) != "":
+		image = getMediaThumbnail(item)
+	default:
+		for _, enclosure := range item.Enclosures {
+			if strings.HasPrefix(enclosure.Type, "image/") {
+				image = enclosure.URL
+				break
+			}
+		}
+	}
+	if image == "" {
+		image = getFirstHtmlImage(item.Content)
+	}
+	if image == "" {
+		image = getFirstHtmlImage(item.Description)
+	}
+	if image == "" {
+		return ""
+	}
+
+	// resolve relative images against the item link
+	base, err := neturl.Parse(item.Link)
+	if err != nil {
+		return image
+	}
+	resolved, err := base.Parse(image)
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return ""
+	}
+	return resolved.String()
+}
+
+func getMediaThumbnail(item *gofeed.Item) string {
+	media, ok := item.Extensions["media"]
+	if !ok {
+		return ""
+	}
+
+	for _, thumbnail := range media["thumbnail"] {
+		if thumbnail.Attrs["url"] != "" {
+			return thumbnail.Attrs["url"]
+		}
+	}
+	// e.g. youtube wraps the thumbnail in media:group
+	for _, group := range media["group"] {
+		for _, thumbnail := range group.Children["thumbnail"] {
+			if thumbnail.Attrs["url"] != "" {
+				return thumbnail.Attrs["url"]
+			}
+		}
+	}
+	for _, content := range media["content"] {
+		if strings.HasPrefix(content.Attrs["type"], "image/") || content.Attrs["medium"] == "image" {
+			return content.Attrs["url"]
+		}
+	}
+
+	return ""
+}
+
+func getFirstHtmlImage(source string) string {
+	if !strings.Contains(source, "<img") {
+		return ""
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(source))
+	if err != nil {
+		return ""
+	}
+	return doc.Find("img[src]").First().AttrOr("src", "")
+}
+
+// uploadRssDigestImages fills ImageKey for items with an image, and reports whether every item got one.
+func uploadRssDigestImages(items []rssDigestItem) bool {
+	wg := sync.WaitGroup{}
+	for i := range items {
+		if items[i].ImageUrl == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			imageKey, err := imageUploader.UploadImage(items[i].ImageUrl)
+			if err != nil {
+				log.Println("error uploading image", items[i].ImageUrl, err)
+				return
+			}
+			items[i].ImageKey = imageKey
+		}()
+	}
+	wg.Wait()
+
+	for i := range items {
+		if items[i].ImageKey == "" {
+			if config.CardImagePlaceholderKey == "" {
+				return false
+			}
+			items[i].ImageKey = config.CardImagePlaceholderKey
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/model"
+)
+
+func TestFeishuImageUploaderUploadsImage(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+
+	imageKey, err := feishuImageUploader{}.UploadImage(standIn.imageUrl("cover.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageKey != "img_cover" {
+		t.Errorf("image key = %q, want img_cover", imageKey)
+	}
+	if len(standIn.uploads) != 1 || standIn.uploads[0] != "cover.png" {
+		t.Errorf("uploads = %v, want [cover.png]", standIn.uploads)
+	}
+}
+
+func TestFeishuImageUploaderRejectsMissingImage(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+
+	if _, err := (feishuImageUploader{}).UploadImage(standIn.imageUrl("missing.png")); err == nil {
+		t.Error("expected an error for a missing image")
+	}
+	if standIn.uploadAttempts != 0 {
+		t.Errorf("upload attempts = %d, want 0", standIn.uploadAttempts)
+	}
+}
+
+func TestCachedImageUploaderUploadsOnce(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+	uploader := NewCachedImageUploader(feishuImageUploader{})
+
+	for i := 0; i < 3; i++ {
+		imageKey, err := uploader.UploadImage(standIn.imageUrl("a.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imageKey != "img_a" {
+			t.Errorf("image key = %q, want img_a", imageKey)
+		}
+	}
+	imageKey, err := uploader.UploadImage(standIn.imageUrl("b.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageKey != "img_b" {
+		t.Errorf("image key = %q, want img_b", imageKey)
+	}
+
+	if len(standIn.uploads) != 2 {
+		t.Errorf("uploads = %v, want one per image", standIn.uploads)
+	}
+}
+
+func TestCachedImageUploaderRetriesFailedUpload(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+	standIn.uploadFailures = 1
+	uploader := NewCachedImageUploader(feishuImageUploader{})
+
+	if _, err := uploader.UploadImage(standIn.imageUrl("a.png")); err == nil {
+		t.Fatal("expected the rate limited upload to fail")
+	}
+	imageKey, err := uploader.UploadImage(standIn.imageUrl("a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageKey != "img_a" {
+		t.Errorf("image key = %q, want img_a", imageKey)
+	}
+	if standIn.uploadAttempts != 2 {
+		t.Errorf("upload attempts = %d, want 2", standIn.uploadAttempts)
+	}
+}
+
+func TestFlatDigestContentSelectsImageCard(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+
+	previous := imageUploader
+	SetImageUploader(NewCachedImageUploader(feishuImageUploader{}))
+	templateId, imageTemplateId, placeholderKey := config.CardTemplateId, config.CardImageTemplateId, config.CardImagePlaceholderKey
+	config.CardTemplateId, config.CardImageTemplateId = "tpl_text", "tpl_image"
+	t.Cleanup(func() {
+		SetImageUploader(previous)
+		config.CardTemplateId, config.CardImageTemplateId, config.CardImagePlaceholderKey = templateId, imageTemplateId, placeholderKey
+	})
+
+	newItem := func(imageUrl string) rssDigestItem {
+		return rssDigestItem{RssFeedItem: RssFeedItem{Title: "title", Link: "https://example.com", ImageUrl: imageUrl}}
+	}
+
+	tests := []struct {
+		name           string
+		placeholderKey string
+		items          []rssDigestItem
+		wantTemplateId string
+		wantImageKeys  []string
+	}{
+		{
+			name:           "every item has an image",
+			items:          []rssDigestItem{newItem(standIn.imageUrl("a.png")), newItem(standIn.imageUrl("b.png"))},
+			wantTemplateId: "tpl_image",
+			wantImageKeys:  []string{"img_a", "img_b"},
+		},
+		{
+			name:           "an item without image",
+			items:          []rssDigestItem{newItem(standIn.imageUrl("a.png")), newItem("")},
+			wantTemplateId: "tpl_text",
+		},
+		{
+			name:           "an image failing to upload",
+			items:          []rssDigestItem{newItem(standIn.imageUrl("a.png")), newItem(standIn.imageUrl("missing.png"))},
+			wantTemplateId: "tpl_text",
+		},
+		{
+			name:           "a placeholder for items without image",
+			placeholderKey: "img_placeholder",
+			items:          []rssDigestItem{newItem(standIn.imageUrl("a.png")), newItem("")},
+			wantTemplateId: "tpl_image",
+			wantImageKeys:  []string{"img_a", "img_placeholder"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CardImagePlaceholderKey = tt.placeholderKey
+
+			var content struct {
+				Type string `json:"type"`
+				Data struct {
+					TemplateId       string `json:"template_id"`
+					TemplateVariable struct {
+						ItemList []model.FeishuMessageItem `json:"itemList"`
+					} `json:"template_variable"`
+				} `json:"data"`
+			}
+			err := json.Unmarshal([]byte(getFlatDigestContent("title", tt.items, time.Now())), &content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if content.Data.TemplateId != tt.wantTemplateId {
+				t.Errorf("template id = %q, want %q", content.Data.TemplateId, tt.wantTemplateId)
+			}
+			if tt.wantImageKeys == nil {
+				return
+			}
+			for i, item := range content.Data.TemplateVariable.ItemList {
+				if item.ImageKey != tt.wantImageKeys[i] {
+					t.Errorf("image key of item %d = %q, want %q", i, item.ImageKey, tt.wantImageKeys[i])
+				}
+			}
+		})
+	}
+}
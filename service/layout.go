@@ -0,0 +1,224 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rhinoc/rss_feishu_bot/config"
+	"github.com/rhinoc/rss_feishu_bot/model"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+// DigestLayout decides how the items of a digest are laid out in the card.
+type DigestLayout string
+
+const (
+	// one list of every item, the feed of each shown as a tag
+	DigestLayoutFlat DigestLayout = ""
+	// a section per feed, under a summary of the digest
+	DigestLayoutGrouped DigestLayout = "grouped"
+)
+
+func ParseDigestLayout(value string) (DigestLayout, error) {
+	switch layout := DigestLayout(value); layout {
+	case DigestLayoutGrouped:
+		return layout, nil
+	case "flat":
+		return DigestLayoutFlat, nil
+	default:
+		return "", fmt.Errorf("unknown layout: %s", value)
+	}
+}
+
+func (layout DigestLayout) String() string {
+	if layout == DigestLayoutFlat {
+		return "flat"
+	}
+	return string(layout)
+}
+
+// getFlatDigestContent renders the flat layout with config.CardTemplateId, or the image variant
+// when the items have images.
+func getFlatDigestContent(cardTitle string, items []rssDigestItem, now time.Time) string {
+	templateId, templateVersionName := config.CardTemplateId, config.CardTemplateVersionName
+	if config.CardImageTemplateId != "" && uploadRssDigestImages(items) {
+		templateId, templateVersionName = config.CardImageTemplateId, config.CardImageTemplateVersionName
+	}
+
+	itemList := []model.FeishuMessageItem{}
+	for _, item := range items {
+		itemList = append(itemList, model.FeishuMessageItem{
+			Title:            item.Title,
+			Link:             item.Link,
+			PrimaryDesc:      item.FeedTitle,
+			PrimaryDescColor: item.FeedColor,
+			SecondaryDesc:    item.TimeLabel(now),
+			Tags:             item.getTagsMarkdown(),
+			Summary:          item.getSummaryMarkdown(),
+			ImageKey:         item.ImageKey,
+		})
+	}
+
+	return string(util.Must(json.Marshal(&model.FeishuMessageContent{
+		Type: "template",
+		Data: model.FeishuMessageData{
+			TemplateId:          templateId,
+			TemplateVersionName: templateVersionName,
+			TemplateVariable: map[string]interface{}{
+				"cardTitle": cardTitle,
+				"cardColor": "blue",
+				"itemList":  itemList,
+			},
+		},
+	})))
+}
+
+type rssDigestSection struct {
+	FeedIndex int
+	FeedTitle string
+	FeedColor string
+	// new items of the feed, not counting an "N more" item
+	ItemCount int
+	Items     []rssDigestItem
+}
+
+// getRssDigestSections groups items by feed, in the order of the feeds in the record.
+func getRssDigestSections(items []rssDigestItem) []*rssDigestSection {
+	sections := []*rssDigestSection{}
+	sectionMap := make(map[int]*rssDigestSection)
+	for _, item := range items {
+		section, ok := sectionMap[item.FeedIndex]
+		if !ok {
+			section = &rssDigestSection{FeedIndex: item.FeedIndex, FeedTitle: item.FeedTitle, FeedColor: item.FeedColor}
+			sectionMap[item.FeedIndex] = section
+			sections = append(sections, section)
+		}
+		section.Items = append(section.Items, item)
+		if !item.More {
+			section.ItemCount++
+		}
+	}
+
+	// items are sorted by time, so the feed of the newest one would come first
+	slices.SortFunc(sections, func(a, b *rssDigestSection) int {
+		return a.FeedIndex - b.FeedIndex
+	})
+
+	return sections
+}
+
+func (section *rssDigestSection) getHeaderMarkdown() string {
+	return fmt.Sprintf("<font color='%s'>**%s**</font> · %d new", section.FeedColor, util.EscapeMarkdown(section.FeedTitle), section.ItemCount)
+}
+
+// getItemsMarkdown renders the items of the section like the flat card does, without the feed tag.
+func (section *rssDigestSection) getItemsMarkdown(now time.Time) string {
+	lines := []string{}
+	for _, item := range section.Items {
+		line := fmt.Sprintf("- **[%s](%s)**", util.EscapeMarkdown(item.Title), markdownLinkReplacer.Replace(item.Link))
+		if label := item.TimeLabel(now); label != "" {
+			line += fmt.Sprintf("  <text_tag color='neutral'>%s</text_tag>", util.EscapeMarkdown(label))
+		}
+		lines = append(lines, line+item.getTagsMarkdown()+item.getSummaryMarkdown())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getRssDigestSummary sums up the digest, e.g. "12 new updates from 3 feeds".
+func getRssDigestSummary(sections []*rssDigestSection, itemCount int) string {
+	if len(sections) == 1 {
+		return fmt.Sprintf("%d new updates from %s", itemCount, sections[0].FeedTitle)
+	}
+	return fmt.Sprintf("%d new updates from %d feeds", itemCount, len(sections))
+}
+
+// getGroupedDigestContent renders the grouped layout with config.CardGroupedTemplateId when set,
+// or else as a card of its own.
+func getGroupedDigestContent(settings RecordItemSettings, cardTitle string, items []rssDigestItem, itemCount int, now time.Time) string {
+	sections := getRssDigestSections(items)
+	summary := getRssDigestSummary(sections, itemCount)
+
+	if config.CardGroupedTemplateId != "" {
+		sectionList := []map[string]interface{}{}
+		for _, section := range sections {
+			sectionList = append(sectionList, map[string]interface{}{
+				"title":    section.FeedTitle,
+				"color":    section.FeedColor,
+				"count":    section.ItemCount,
+				"header":   section.getHeaderMarkdown(),
+				"content":  section.getItemsMarkdown(now),
+				"expanded": !settings.CollapseSections,
+			})
+		}
+
+		return string(util.Must(json.Marshal(&model.FeishuMessageContent{
+			Type: "template",
+			Data: model.FeishuMessageData{
+				TemplateId:          config.CardGroupedTemplateId,
+				TemplateVersionName: config.CardGroupedTemplateVersionName,
+				TemplateVariable: map[string]interface{}{
+					"cardTitle":    cardTitle,
+					"cardSubTitle": summary,
+					"cardColor":    "blue",
+					"sectionList":  sectionList,
+				},
+			},
+		})))
+	}
+
+	// https://open.feishu.cn/document/uAjLw4CM/ukzMukzMukzM/feishu-cards/card-json-v2-structure
+	elements := []interface{}{}
+	for i, section := range sections {
+		content := map[string]interface{}{
+			"tag":     "markdown",
+			"content": section.getItemsMarkdown(now),
+		}
+
+		if settings.CollapseSections {
+			elements = append(elements, map[string]interface{}{
+				"tag":      "collapsible_panel",
+				"expanded": false,
+				"header": map[string]interface{}{
+					"title": map[string]interface{}{
+						"tag":     "markdown",
+						"content": section.getHeaderMarkdown(),
+					},
+					"icon": map[string]interface{}{
+						"tag":   "standard_icon",
+						"token": "down-small-ccm_outlined",
+					},
+					"icon_position":       "right",
+					"icon_expanded_angle": -180,
+				},
+				"elements": []interface{}{content},
+			})
+			continue
+		}
+
+		if i > 0 {
+			elements = append(elements, map[string]interface{}{"tag": "hr"})
+		}
+		elements = append(elements, map[string]interface{}{
+			"tag":     "markdown",
+			"content": section.getHeaderMarkdown(),
+		}, content)
+	}
+
+	return string(util.Must(json.Marshal(map[string]interface{}{
+		"schema": "2.0",
+		"config": map[string]interface{}{
+			"update_multi": true,
+		},
+		"header": map[string]interface{}{
+			"title":    map[string]interface{}{"tag": "plain_text", "content": cardTitle},
+			"subtitle": map[string]interface{}{"tag": "plain_text", "content": summary},
+			"template": "blue",
+		},
+		"body": map[string]interface{}{
+			"elements": elements,
+		},
+	})))
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupedDigestItemsEscapeLink(t *testing.T) {
+	section := &rssDigestSection{
+		Items: []rssDigestItem{{
+			RssFeedItem: RssFeedItem{Title: "Go", Link: "https://example.com/wiki/Go_(language) v2"},
+		}},
+	}
+
+	markdown := section.getItemsMarkdown(time.Now())
+	want := "(https://example.com/wiki/Go_%28language%29%20v2)"
+	if !strings.Contains(markdown, want) {
+		t.Fatalf("getItemsMarkdown() = %q, want link %q", markdown, want)
+	}
+}
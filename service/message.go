@@ -1,12 +1,11 @@
 package service
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 
+	"github.com/rhinoc/rss_feishu_bot/config"
 	"github.com/rhinoc/rss_feishu_bot/util"
 )
 
@@ -21,45 +20,14 @@ func FeishuSendMessage(req FeishuSendMessageRequest) error {
 	if req.ReceiveIdType == "" {
 		req.ReceiveIdType = "open_id"
 	}
-	url := fmt.Sprintf("https://open.feishu.cn/open-apis/im/v1/messages?receive_id_type=%s", req.ReceiveIdType)
+	url := fmt.Sprintf("%s/open-apis/im/v1/messages?receive_id_type=%s", config.FeishuBaseUrl, req.ReceiveIdType)
 
-	// Convert the request to JSON
-	jsonData, err := json.Marshal(req)
-	fmt.Println("send message request", string(jsonData))
-	if err != nil {
-		return fmt.Errorf("error marshaling request: %w", err)
-	}
-
-	// Create a new HTTP POST request
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	accessToken, err := GetAccessToken()
-	if err != nil {
-		return fmt.Errorf("error getting access token: %w", err)
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
-	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Println("send message request", string(util.Must(json.Marshal(req))))
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// print response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
-	}
+	body, err := feishuDo(http.MethodPost, url, req, true, nil)
 	fmt.Println("send message response", string(body))
 
-	return nil
+	return err
 }
 
 func FeishuSendMessageText(receiveId, receiveIdType, content string) error {
@@ -87,11 +55,26 @@ type FeishuReceivedMessageRequest struct {
 }
 
 type FeishuReceivedMessage struct {
-	ChatId     string `json:"chat_id"`
-	ChatType   string `json:"chat_type"`
-	Content    string `json:"content"`
-	Text       string `json:"text"`
-	CreateTime string `json:"create_time"`
+	MessageId   string `json:"message_id"`
+	MessageType string `json:"message_type"`
+	ChatId      string `json:"chat_id"`
+	ChatType    string `json:"chat_type"`
+	Content     string `json:"content"`
+	Text        string `json:"text"`
+	// set for file messages
+	FileKey    string          `json:"file_key"`
+	FileName   string          `json:"file_name"`
+	CreateTime string          `json:"create_time"`
+	Mentions   []FeishuMention `json:"mentions"`
+}
+
+// FeishuMention is a user mentioned in a message, whose text carries Key, e.g. "@_user_1", in place of the name.
+type FeishuMention struct {
+	Key string `json:"key"`
+	Id  struct {
+		OpenId string `json:"open_id"`
+	} `json:"id"`
+	Name string `json:"name"`
 }
 
 type FeishuSender struct {
@@ -104,9 +87,11 @@ type FeishuSender struct {
 }
 
 type FeishuReceivedMessageContent struct {
-	Title   string `json:"title"`
-	Text    string `json:"text"`
-	Content [][]struct {
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+	FileKey  string `json:"file_key"`
+	FileName string `json:"file_name"`
+	Content  [][]struct {
 		Tag  string `json:"tag"`
 		Text string `json:"text"`
 	} `json:"content"`
@@ -134,11 +119,13 @@ func FeishuGetMessageReq(data []byte) (*FeishuReceivedMessageRequest, error) {
 		}
 	}
 
-	if len(text) == 0 {
+	if len(text) == 0 && content.FileKey == "" {
 		return nil, fmt.Errorf("empty message content")
 	}
 
 	req.Event.Message.Text = text
+	req.Event.Message.FileKey = content.FileKey
+	req.Event.Message.FileName = content.FileName
 
 	return &req, nil
 }
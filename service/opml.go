@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+var (
+	ErrInvalidOpml = errors.New("the file is not an OPML document")
+	ErrNoOpmlFeed  = errors.New("no feed was found in the OPML document")
+)
+
+type Opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OpmlHead `xml:"head"`
+	Body    OpmlBody `xml:"body"`
+}
+
+type OpmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OpmlBody struct {
+	Outlines []OpmlOutline `xml:"outline"`
+}
+
+// OpmlOutline is a feed when XmlUrl is set, or else a folder of outlines.
+type OpmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XmlUrl   string        `xml:"xmlUrl,attr,omitempty"`
+	HtmlUrl  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []OpmlOutline `xml:"outline"`
+}
+
+// ExportOpml lists the feeds of recordItem as an OPML document. Credentials are never exported.
+func ExportOpml(recordItem RecordItem) []byte {
+	opml := Opml{
+		Version: "2.0",
+		Head: OpmlHead{
+			Title:       "RSS Feishu Bot subscriptions",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, feed := range recordItem.FeedList {
+		xmlUrl := feed.Link
+		// other readers don't know about source shortcuts
+		if adapter := GetSourceAdapter(feed.Link); adapter != nil {
+			if url, err := adapter.Expand(feed.Link); err == nil {
+				xmlUrl = url
+			} else {
+				log.Println("error expanding source for export", feed.Link, err)
+			}
+		}
+
+		opml.Body.Outlines = append(opml.Body.Outlines, OpmlOutline{
+			Text:   feed.Link,
+			Type:   "rss",
+			XmlUrl: xmlUrl,
+		})
+	}
+
+	data, _ := xml.MarshalIndent(opml, "", "  ")
+	return append([]byte(xml.Header), data...)
+}
+
+// ParseOpml returns the normalized feed urls of an OPML document, folders flattened, without duplicates.
+func ParseOpml(data []byte) ([]string, error) {
+	var opml Opml
+	if err := xml.Unmarshal(data, &opml); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOpml, err)
+	}
+
+	urls := []string{}
+	seen := make(map[string]bool)
+	var walk func(outlines []OpmlOutline)
+	walk = func(outlines []OpmlOutline) {
+		for _, outline := range outlines {
+			url := util.TrimUrl(outline.XmlUrl)
+			if url != "" && !seen[util.UrlKey(url)] {
+				seen[util.UrlKey(url)] = true
+				urls = append(urls, url)
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(opml.Body.Outlines)
+
+	if len(urls) == 0 {
+		return nil, ErrNoOpmlFeed
+	}
+	return urls, nil
+}
+
+// DownloadOpml fetches an OPML document, e.g. the export url of another reader.
+func DownloadOpml(url string) ([]byte, error) {
+	resp, err := defaultRssFetcher.get(RssFetchRequest{Url: url})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching opml: %w", gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		})
+	}
+	return resp.Body, nil
+}
+
+type OpmlImportResult struct {
+	Added   []string
+	Existed []string
+	Failed  []RssFetchBatchResult
+}
+
+// ImportOpml subscribes the record of targetOpenId, created if needed, to every feed of an OPML document
+// that isn't subscribed yet. Each feed is validated first, those that fail are reported and skipped.
+func ImportOpml(targetOpenId string, isGroup bool, data []byte) (*OpmlImportResult, error) {
+	urls, err := ParseOpml(data)
+	if err != nil {
+		return nil, err
+	}
+
+	store := GetRecordStore()
+	recordItem, err := store.GetRecordItem(targetOpenId, isGroup)
+	isNew := errors.Is(err, ErrRecordNotFound)
+	if err != nil && !isNew {
+		return nil, err
+	}
+	if isNew {
+		recordItem = &RecordItem{}
+		if isGroup {
+			recordItem.GroupOpenId = targetOpenId
+		} else {
+			recordItem.UserOpenId = targetOpenId
+		}
+	}
+
+	result := &OpmlImportResult{Added: []string{}, Existed: []string{}}
+	reqs := []RssFetchRequest{}
+	for _, url := range urls {
+		if recordItem.FindFeed(url) != nil {
+			result.Existed = append(result.Existed, url)
+			continue
+		}
+		reqs = append(reqs, RssFetchRequest{Url: url})
+	}
+
+	for _, fetchResult := range FetchRSSFeedsParallel(reqs, nil) {
+		if fetchResult.Err != nil {
+			result.Failed = append(result.Failed, fetchResult)
+			continue
+		}
+		// another entry may have redirected to the same feed
+		if recordItem.FindFeed(fetchResult.Result.Url) != nil {
+			result.Existed = append(result.Existed, fetchResult.Url)
+			continue
+		}
+
+		feed := &RecordItemFeed{Link: fetchResult.Url}
+		feed.SetResolvedLink(fetchResult.Result.Url)
+		result.Added = append(result.Added, fetchResult.Url)
+		recordItem.FeedList = append(recordItem.FeedList, feed)
+	}
+
+	if len(result.Added) == 0 {
+		return result, nil
+	}
+	if isNew {
+		_, err = store.AddRecordItem(*recordItem)
+	} else {
+		err = store.UpdateRecordItemFeedList(*recordItem)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
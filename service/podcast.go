@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+type RssFeedItemEnclosure struct {
+	Url    string `json:"url"`
+	Type   string `json:"type"`
+	Length int64  `json:"length,omitempty"`
+}
+
+var mediaExtensions = []string{".mp3", ".m4a", ".aac", ".ogg", ".opus", ".wav", ".mp4", ".m4v", ".mov", ".webm"}
+
+// getRssFeedItemEnclosure returns the first audio or video enclosure of the item.
+func getRssFeedItemEnclosure(item *gofeed.Item) *RssFeedItemEnclosure {
+	for _, enclosure := range item.Enclosures {
+		if enclosure.URL == "" || !isMediaEnclosure(enclosure) {
+			continue
+		}
+
+		length, _ := strconv.ParseInt(strings.TrimSpace(enclosure.Length), 10, 64)
+		return &RssFeedItemEnclosure{
+			Url:    enclosure.URL,
+			Type:   enclosure.Type,
+			Length: length,
+		}
+	}
+	return nil
+}
+
+func isMediaEnclosure(enclosure *gofeed.Enclosure) bool {
+	if strings.HasPrefix(enclosure.Type, "audio/") || strings.HasPrefix(enclosure.Type, "video/") {
+		return true
+	}
+	if enclosure.Type != "" {
+		return false
+	}
+
+	link := strings.ToLower(strings.SplitN(enclosure.URL, "?", 2)[0])
+	for _, extension := range mediaExtensions {
+		if strings.HasSuffix(link, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRssFeedItemDuration parses itunes:duration, given either in seconds or as [[hh:]mm:]ss.
+func getRssFeedItemDuration(item *gofeed.Item) time.Duration {
+	if item.ITunesExt == nil {
+		return 0
+	}
+
+	duration := time.Duration(0)
+	for _, part := range strings.Split(strings.TrimSpace(item.ITunesExt.Duration), ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		duration = duration*60 + time.Duration(value*float64(time.Second))
+	}
+	return duration
+}
+
+func formatDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func formatFileSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+var markdownLinkReplacer = strings.NewReplacer("(", "%28", ")", "%29", " ", "%20")
+
+// GetPodcastMarkdown renders the duration tag and play link shown next to a podcast item.
+func GetPodcastMarkdown(item RssFeedItem) string {
+	if item.Enclosure == nil {
+		return ""
+	}
+
+	markdown := ""
+	if item.Duration > 0 {
+		markdown += fmt.Sprintf("<text_tag color='violet'>%s</text_tag>", formatDuration(item.Duration))
+	} else if item.Enclosure.Length > 0 {
+		markdown += fmt.Sprintf("<text_tag color='violet'>%s</text_tag>", formatFileSize(item.Enclosure.Length))
+	}
+
+	label := "Play"
+	if strings.HasPrefix(item.Enclosure.Type, "video/") {
+		label = "Watch"
+	}
+	return markdown + fmt.Sprintf(" [%s](%s)", util.EscapeMarkdown(label), markdownLinkReplacer.Replace(item.Enclosure.Url))
+}
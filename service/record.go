@@ -1,210 +1,158 @@
 package service
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/rhinoc/rss_feishu_bot/config"
 	"github.com/rhinoc/rss_feishu_bot/util"
 )
 
+var ErrRecordNotFound = errors.New("record not found")
+
 type RecordItemFeed struct {
 	Link         string `json:"link"`
 	LastReadLink string `json:"last_read_link"`
+	// where Link redirects to, or the url a source uri expands to, see SetResolvedLink
+	ResolvedLink string `json:"resolved_link,omitempty"`
+	// keys of delivered items, newest first, see getRssFeedItemKey
+	SeenItemList []string `json:"seen_item_list,omitempty"`
+	// set for web pages without a feed
+	Selector *RecordItemFeedSelector `json:"selector,omitempty"`
+	// credentials and headers sent when fetching, encrypted at rest
+	Auth *RecordItemFeedAuth `json:"auth,omitempty"`
+	// items sent per digest, 0 for config.DefaultItemLimitPerFeed
+	ItemLimit int `json:"item_limit,omitempty"`
+	// what happens to new items beyond ItemLimit
+	CatchUp FeedCatchUpMode `json:"catch_up,omitempty"`
+	// rules of /filter, see FeedFilterRule
+	Filters []string `json:"filters,omitempty"`
+	// show a plain text summary under each item in digests
+	ShowSummary bool `json:"show_summary,omitempty"`
+	// validators for conditional GET
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// health, see HealthStatus
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	FailureCount  int        `json:"failure_count,omitempty"`
+	Suspended     bool       `json:"suspended,omitempty"`
 }
 
 type RecordItem struct {
-	Id          string            `json:"id"`
-	UserOpenId  string            `json:"user_open_id"`
-	GroupOpenId string            `json:"group_open_id"`
-	FeedList    []*RecordItemFeed `json:"feed_list"`
+	Id          string             `json:"id"`
+	UserOpenId  string             `json:"user_open_id"`
+	GroupOpenId string             `json:"group_open_id"`
+	FeedList    []*RecordItemFeed  `json:"feed_list"`
+	Settings    RecordItemSettings `json:"settings"`
 }
 
-func GetRecordList() ([]RecordItem, error) {
-	records, err := FeishuGetBitableRecord(config.BitableAppToken, config.BitableTableId, FeishuGetBitableRecordRequest{
-		ViewId: config.BitableViewId,
-		Filter: BitableRecordFilterInfo{
-			Conjunction: "and",
-			Conditions: []BitableRecordCondition{
-				{
-					FieldName: "feedList",
-					Operator:  "isNotEmpty",
-					Value:     []string{},
-				},
-				{
-					FieldName: "enable",
-					Operator:  "is",
-					Value:     []string{"true"},
-				},
-			},
-		},
-	})
-	if err != nil {
-		return nil, err
+// mergeFeedState copies the read and health state of the feeds of source into the same feeds of recordItem,
+// leaving their settings, which may have changed meanwhile, alone.
+func (recordItem *RecordItem) mergeFeedState(source RecordItem) {
+	states := make(map[string]*RecordItemFeed, len(source.FeedList))
+	for _, feed := range source.FeedList {
+		states[feed.Link] = feed
 	}
 
-	recordItems := make([]RecordItem, 0)
-	for _, record := range records.Data.Items {
-		recordItems = append(recordItems, getRecordItem(record))
+	for _, feed := range recordItem.FeedList {
+		state, ok := states[feed.Link]
+		if !ok {
+			continue
+		}
+		feed.LastReadLink = state.LastReadLink
+		feed.ResolvedLink = state.ResolvedLink
+		feed.SeenItemList = state.SeenItemList
+		feed.ETag = state.ETag
+		feed.LastModified = state.LastModified
+		feed.LastSuccessAt = state.LastSuccessAt
+		feed.LastErrorAt = state.LastErrorAt
+		feed.LastError = state.LastError
+		feed.FailureCount = state.FailureCount
+		feed.Suspended = state.Suspended
 	}
-
-	recordItems = util.Filter(recordItems, func(item RecordItem) bool {
-		return item.GroupOpenId != "" || item.UserOpenId != ""
-	})
-
-	return recordItems, nil
 }
 
-func GetRecordItem(id string, isGroup bool) (*RecordItem, error) {
-	field := "user"
-	if isGroup {
-		field = "group"
-	}
-
-	records, err := FeishuGetBitableRecord(config.BitableAppToken, config.BitableTableId, FeishuGetBitableRecordRequest{
-		ViewId: config.BitableViewId,
-		Filter: BitableRecordFilterInfo{
-			Conjunction: "and",
-			Conditions: []BitableRecordCondition{
-				{
-					FieldName: field,
-					Operator:  "is",
-					Value:     []string{id},
-				},
-			},
-		},
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(records.Data.Items) == 0 {
-		return nil, fmt.Errorf("record not found")
-	}
-
-	if len(records.Data.Items) > 1 {
-		log.Println("multiple records found", records.Data.Items)
-	}
-
-	item := getRecordItem(records.Data.Items[0])
-	return &item, nil
+// RecordItemSettings applies to every feed of a record.
+type RecordItemSettings struct {
+	AlertList        []*RecordItemAlert `json:"alert_list,omitempty"`
+	Layout           DigestLayout       `json:"layout,omitempty"`
+	CollapseSections bool               `json:"collapse_sections,omitempty"`
 }
 
-func getLastReadLinkMap(source []interface{}) map[string]string {
-	jsonStr := ""
-
-	for _, item := range source {
-		if sourceItem, ok := item.(map[string]interface{}); ok {
-			if text, ok := sourceItem["text"].(string); ok {
-				jsonStr += text
-			}
-		}
-	}
-
-	jsonMap := make(map[string]string)
-	json.Unmarshal([]byte(jsonStr), &jsonMap)
-
-	return jsonMap
+type RecordStore interface {
+	GetRecordList() ([]RecordItem, error)
+	// ForEachRecordItem streams every active record to f, stopping at the first error f returns.
+	ForEachRecordItem(f func(item RecordItem) error) error
+	GetRecordItem(id string, isGroup bool) (*RecordItem, error)
+	AddRecordItem(recordItem RecordItem) (string, error)
+	UpdateRecordItemFeedList(recordItem RecordItem) error
+	// UpdateRecordItemFeedState persists only the per-feed read and health state, e.g. SeenItemList and FailureCount,
+	// so that settings changed meanwhile are kept.
+	UpdateRecordItemFeedState(recordItem RecordItem) error
+	UpdateRecordItemSettings(recordItem RecordItem) error
 }
 
-func getRecordItem(source FeishuGetBitableRecordItem) RecordItem {
-	item := RecordItem{
-		Id: source.RecordID,
-	}
-
-	// Extract user_open_id
-	if users, ok := source.Fields["user"].([]interface{}); ok && len(users) > 0 {
-		if user, ok := users[0].(map[string]interface{}); ok {
-			if id, ok := user["id"].(string); ok {
-				item.UserOpenId = id
-			}
-		}
-	}
-
-	// Extract group_open_id
-	if groups, ok := source.Fields["group"].([]interface{}); ok && len(groups) > 0 {
-		if group, ok := groups[0].(map[string]interface{}); ok {
-			if id, ok := group["id"].(string); ok {
-				item.GroupOpenId = id
-			}
-		}
-	}
-
-	// Extract feed_list
-	feedList, _ := source.Fields["feedList"].([]interface{})
-	lastReadLinkList := make(map[string]string)
-	if source.Fields["lastReadLinkList"] != nil {
-		lastReadLinkList = getLastReadLinkMap(source.Fields["lastReadLinkList"].([]interface{}))
-	}
+var (
+	recordStore     RecordStore
+	recordStoreOnce sync.Once
+)
 
-	if len(feedList) > 0 {
-		item.FeedList = make([]*RecordItemFeed, 0, len(feedList))
-		for _, feedLink := range feedList {
-			item.FeedList = append(item.FeedList, &RecordItemFeed{
-				Link:         feedLink.(string),
-				LastReadLink: lastReadLinkList[feedLink.(string)],
-			})
+func GetRecordStore() RecordStore {
+	recordStoreOnce.Do(func() {
+		store, err := NewRecordStore(config.RecordStoreType)
+		if err != nil {
+			log.Fatalln("error creating record store", err)
 		}
-	}
-
-	return item
+		recordStore = store
+	})
+	return recordStore
 }
 
-func UpdateRecordItemLastReadLink(recordItem RecordItem) error {
-	lastReadLinkList := make(map[string]interface{})
-	for _, feed := range recordItem.FeedList {
-		lastReadLinkList[feed.Link] = feed.LastReadLink
+func NewRecordStore(storeType string) (RecordStore, error) {
+	switch storeType {
+	case "", "bitable":
+		return NewBitableRecordStore(config.BitableAppToken, config.BitableTableId, config.BitableViewId, config.BitablePageSize), nil
+	case "local":
+		return NewLocalRecordStore(config.LocalRecordStorePath)
+	default:
+		return nil, fmt.Errorf("unknown record store type: %s", storeType)
 	}
-
-	return FeishuUpdateBitableRecord(config.BitableAppToken, config.BitableTableId, recordItem.Id, FeishuUpdateBitableRecordRequest{
-		Fields: map[string]interface{}{
-			"lastReadLinkList": string(util.Must(json.Marshal(lastReadLinkList))),
-		},
-	})
 }
 
-func UpdateRecordItemFeedList(recordItem RecordItem) error {
-	feedList := make([]string, 0, len(recordItem.FeedList))
-	for _, feed := range recordItem.FeedList {
-		feedList = append(feedList, feed.Link)
+// getRecordItemReceiver returns the receive_id and receive_id_type to message the subscriber of recordItem.
+func getRecordItemReceiver(recordItem RecordItem) (string, string) {
+	if recordItem.GroupOpenId != "" {
+		return recordItem.GroupOpenId, "chat_id"
 	}
-	return FeishuUpdateBitableRecord(config.BitableAppToken, config.BitableTableId, recordItem.Id, FeishuUpdateBitableRecordRequest{
-		Fields: map[string]interface{}{
-			"feedList": feedList,
-		},
-	})
+	return recordItem.UserOpenId, "open_id"
 }
 
-func AddRecordItem(recordItem RecordItem) (string, error) {
-	feedList := make([]string, 0, len(recordItem.FeedList))
+// FindFeed returns the subscribed feed with the given link, or nil.
+// Links are compared in their canonical form, and a feed is also found by where it redirects to.
+func (recordItem *RecordItem) FindFeed(link string) *RecordItemFeed {
+	key := util.UrlKey(link)
 	for _, feed := range recordItem.FeedList {
-		feedList = append(feedList, feed.Link)
-	}
-
-	fields := map[string]interface{}{
-		"feedList": feedList,
-		"enable":   true,
-	}
-
-	if recordItem.UserOpenId != "" {
-		fields["user"] = []map[string]interface{}{
-			{
-				"id": recordItem.UserOpenId,
-			},
+		if util.UrlKey(feed.Link) == key || feed.ResolvedLink != "" && util.UrlKey(feed.ResolvedLink) == key {
+			return feed
 		}
 	}
+	return nil
+}
 
-	if recordItem.GroupOpenId != "" {
-		fields["group"] = []map[string]interface{}{
-			{
-				"id": recordItem.GroupOpenId,
-			},
-		}
+// SetResolvedLink records the final url the feed was fetched from, if it isn't Link itself.
+func (feed *RecordItemFeed) SetResolvedLink(finalUrl string) {
+	if finalUrl == "" || util.UrlKey(finalUrl) == util.UrlKey(feed.Link) {
+		feed.ResolvedLink = ""
+		return
 	}
+	feed.ResolvedLink = finalUrl
+}
 
-	return FeishuAddBitableRecord(config.BitableAppToken, config.BitableTableId, FeishuAddBitableRecordRequest{
-		Fields: fields,
-	})
+func isRecordItemActive(item RecordItem) bool {
+	return (item.GroupOpenId != "" || item.UserOpenId != "") && len(item.FeedList) > 0
 }
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+type BitableRecordStore struct {
+	AppToken string
+	TableId  string
+	ViewId   string
+	PageSize int
+}
+
+func NewBitableRecordStore(appToken string, tableId string, viewId string, pageSize int) *BitableRecordStore {
+	return &BitableRecordStore{
+		AppToken: appToken,
+		TableId:  tableId,
+		ViewId:   viewId,
+		PageSize: pageSize,
+	}
+}
+
+func (s *BitableRecordStore) GetRecordList() ([]RecordItem, error) {
+	recordItems := make([]RecordItem, 0)
+	err := s.ForEachRecordItem(func(item RecordItem) error {
+		recordItems = append(recordItems, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recordItems, nil
+}
+
+func (s *BitableRecordStore) ForEachRecordItem(f func(item RecordItem) error) error {
+	req := FeishuGetBitableRecordRequest{
+		ViewId: s.ViewId,
+		Filter: BitableRecordFilterInfo{
+			Conjunction: "and",
+			Conditions: []BitableRecordCondition{
+				{
+					FieldName: "feedList",
+					Operator:  "isNotEmpty",
+					Value:     []string{},
+				},
+				{
+					FieldName: "enable",
+					Operator:  "is",
+					Value:     []string{"true"},
+				},
+			},
+		},
+		PageSize: s.PageSize,
+	}
+
+	for {
+		records, err := FeishuGetBitableRecord(s.AppToken, s.TableId, req)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records.Data.Items {
+			item := getRecordItem(record)
+			if item.GroupOpenId == "" && item.UserOpenId == "" {
+				continue
+			}
+			if err := f(item); err != nil {
+				return err
+			}
+		}
+
+		if !records.Data.HasMore || records.Data.PageToken == "" {
+			return nil
+		}
+		req.PageToken = records.Data.PageToken
+	}
+}
+
+func (s *BitableRecordStore) GetRecordItem(id string, isGroup bool) (*RecordItem, error) {
+	field := "user"
+	if isGroup {
+		field = "group"
+	}
+
+	records, err := FeishuGetBitableRecord(s.AppToken, s.TableId, FeishuGetBitableRecordRequest{
+		ViewId: s.ViewId,
+		Filter: BitableRecordFilterInfo{
+			Conjunction: "and",
+			Conditions: []BitableRecordCondition{
+				{
+					FieldName: field,
+					Operator:  "is",
+					Value:     []string{id},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records.Data.Items) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	if len(records.Data.Items) > 1 {
+		log.Println("multiple records found", records.Data.Items)
+	}
+
+	item := getRecordItem(records.Data.Items[0])
+	return &item, nil
+}
+
+func getTextFieldValue(source interface{}) string {
+	// a single record comes with plain text fields, search results with segments
+	if text, ok := source.(string); ok {
+		return text
+	}
+
+	text := ""
+
+	segments, _ := source.([]interface{})
+	for _, item := range segments {
+		if sourceItem, ok := item.(map[string]interface{}); ok {
+			if segment, ok := sourceItem["text"].(string); ok {
+				text += segment
+			}
+		}
+	}
+
+	return text
+}
+
+func getLastReadLinkMap(source interface{}) map[string]string {
+	jsonMap := make(map[string]string)
+	json.Unmarshal([]byte(getTextFieldValue(source)), &jsonMap)
+
+	return jsonMap
+}
+
+func getFeedStateMap(source interface{}) map[string]*RecordItemFeed {
+	jsonMap := make(map[string]*RecordItemFeed)
+	json.Unmarshal([]byte(getTextFieldValue(source)), &jsonMap)
+
+	return jsonMap
+}
+
+func getRecordItemSettings(source interface{}) RecordItemSettings {
+	var settings RecordItemSettings
+	json.Unmarshal([]byte(getTextFieldValue(source)), &settings)
+
+	return settings
+}
+
+func getRecordItem(source FeishuGetBitableRecordItem) RecordItem {
+	item := RecordItem{
+		Id: source.RecordID,
+	}
+
+	// Extract user_open_id
+	if users, ok := source.Fields["user"].([]interface{}); ok && len(users) > 0 {
+		if user, ok := users[0].(map[string]interface{}); ok {
+			if id, ok := user["id"].(string); ok {
+				item.UserOpenId = id
+			}
+		}
+	}
+
+	// Extract group_open_id
+	if groups, ok := source.Fields["group"].([]interface{}); ok && len(groups) > 0 {
+		if group, ok := groups[0].(map[string]interface{}); ok {
+			if id, ok := group["id"].(string); ok {
+				item.GroupOpenId = id
+			}
+		}
+	}
+
+	item.Settings = getRecordItemSettings(source.Fields["settings"])
+
+	// Extract feed_list
+	feedList, _ := source.Fields["feedList"].([]interface{})
+	lastReadLinkList := getLastReadLinkMap(source.Fields["lastReadLinkList"])
+	feedStateList := getFeedStateMap(source.Fields["feedStateList"])
+
+	if len(feedList) > 0 {
+		item.FeedList = make([]*RecordItemFeed, 0, len(feedList))
+		for _, feedLink := range feedList {
+			link, _ := feedLink.(string)
+			feed := feedStateList[link]
+			if feed == nil {
+				feed = &RecordItemFeed{}
+			}
+			feed.Link = link
+			if feed.LastReadLink == "" {
+				feed.LastReadLink = lastReadLinkList[link]
+			}
+			item.FeedList = append(item.FeedList, feed)
+		}
+	}
+
+	return item
+}
+
+func getFeedStateList(recordItem RecordItem) string {
+	feedStateList := make(map[string]*RecordItemFeed)
+	for _, feed := range recordItem.FeedList {
+		feedStateList[feed.Link] = feed
+	}
+	return string(util.Must(json.Marshal(feedStateList)))
+}
+
+// UpdateRecordItemFeedState re-reads the record first, so that settings changed while feeds were being fetched are kept.
+func (s *BitableRecordStore) UpdateRecordItemFeedState(recordItem RecordItem) error {
+	source, err := FeishuGetBitableRecordById(s.AppToken, s.TableId, recordItem.Id)
+	if err != nil {
+		return err
+	}
+	current := getRecordItem(*source)
+	current.mergeFeedState(recordItem)
+
+	lastReadLinkList := make(map[string]interface{})
+	for _, feed := range current.FeedList {
+		lastReadLinkList[feed.Link] = feed.LastReadLink
+	}
+
+	return FeishuUpdateBitableRecord(s.AppToken, s.TableId, recordItem.Id, FeishuUpdateBitableRecordRequest{
+		Fields: map[string]interface{}{
+			"lastReadLinkList": string(util.Must(json.Marshal(lastReadLinkList))),
+			"feedStateList":    getFeedStateList(current),
+		},
+	})
+}
+
+func (s *BitableRecordStore) UpdateRecordItemFeedList(recordItem RecordItem) error {
+	feedList := make([]string, 0, len(recordItem.FeedList))
+	for _, feed := range recordItem.FeedList {
+		feedList = append(feedList, feed.Link)
+	}
+	return FeishuUpdateBitableRecord(s.AppToken, s.TableId, recordItem.Id, FeishuUpdateBitableRecordRequest{
+		Fields: map[string]interface{}{
+			"feedList":      feedList,
+			"feedStateList": getFeedStateList(recordItem),
+		},
+	})
+}
+
+func (s *BitableRecordStore) UpdateRecordItemSettings(recordItem RecordItem) error {
+	return FeishuUpdateBitableRecord(s.AppToken, s.TableId, recordItem.Id, FeishuUpdateBitableRecordRequest{
+		Fields: map[string]interface{}{
+			"settings": string(util.Must(json.Marshal(recordItem.Settings))),
+		},
+	})
+}
+
+func (s *BitableRecordStore) AddRecordItem(recordItem RecordItem) (string, error) {
+	feedList := make([]string, 0, len(recordItem.FeedList))
+	for _, feed := range recordItem.FeedList {
+		feedList = append(feedList, feed.Link)
+	}
+
+	fields := map[string]interface{}{
+		"feedList":      feedList,
+		"feedStateList": getFeedStateList(recordItem),
+		"enable":        true,
+	}
+
+	if recordItem.UserOpenId != "" {
+		fields["user"] = []map[string]interface{}{
+			{
+				"id": recordItem.UserOpenId,
+			},
+		}
+	}
+
+	if recordItem.GroupOpenId != "" {
+		fields["group"] = []map[string]interface{}{
+			{
+				"id": recordItem.GroupOpenId,
+			},
+		}
+	}
+
+	return FeishuAddBitableRecord(s.AppToken, s.TableId, FeishuAddBitableRecordRequest{
+		Fields: fields,
+	})
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rhinoc/rss_feishu_bot/util"
+)
+
+// LocalRecordStore keeps records in a JSON file, for running the bot without a Bitable.
+type LocalRecordStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLocalRecordStore(path string) (*LocalRecordStore, error) {
+	store := &LocalRecordStore{path: path}
+
+	// make sure the file is readable before serving requests
+	if _, err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *LocalRecordStore) load() ([]RecordItem, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []RecordItem{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading record file: %w", err)
+	}
+
+	recordItems := []RecordItem{}
+	if len(data) == 0 {
+		return recordItems, nil
+	}
+	err = json.Unmarshal(data, &recordItems)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling record file: %w", err)
+	}
+
+	return recordItems, nil
+}
+
+func (s *LocalRecordStore) save(recordItems []RecordItem) error {
+	data, err := json.MarshalIndent(recordItems, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling record file: %w", err)
+	}
+
+	// write to a temp file first so a crash never leaves a truncated file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temp record file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing record file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing record file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *LocalRecordStore) update(id string, f func(item *RecordItem)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordItems, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	for i := range recordItems {
+		if recordItems[i].Id == id {
+			f(&recordItems[i])
+			return s.save(recordItems)
+		}
+	}
+
+	return ErrRecordNotFound
+}
+
+func (s *LocalRecordStore) GetRecordList() ([]RecordItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordItems, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return util.Filter(recordItems, isRecordItemActive), nil
+}
+
+func (s *LocalRecordStore) ForEachRecordItem(f func(item RecordItem) error) error {
+	recordItems, err := s.GetRecordList()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range recordItems {
+		if err := f(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *LocalRecordStore) GetRecordItem(id string, isGroup bool) (*RecordItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordItems, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range recordItems {
+		if (isGroup && item.GroupOpenId == id) || (!isGroup && item.UserOpenId == id) {
+			return &item, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s *LocalRecordStore) AddRecordItem(recordItem RecordItem) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordItems, err := s.load()
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("error generating record id: %w", err)
+	}
+	recordItem.Id = "rec" + hex.EncodeToString(id)
+
+	err = s.save(append(recordItems, recordItem))
+	if err != nil {
+		return "", err
+	}
+
+	return recordItem.Id, nil
+}
+
+func (s *LocalRecordStore) UpdateRecordItemFeedList(recordItem RecordItem) error {
+	return s.update(recordItem.Id, func(item *RecordItem) {
+		item.FeedList = recordItem.FeedList
+	})
+}
+
+func (s *LocalRecordStore) UpdateRecordItemSettings(recordItem RecordItem) error {
+	return s.update(recordItem.Id, func(item *RecordItem) {
+		item.Settings = recordItem.Settings
+	})
+}
+
+func (s *LocalRecordStore) UpdateRecordItemFeedState(recordItem RecordItem) error {
+	return s.update(recordItem.Id, func(item *RecordItem) {
+		item.mergeFeedState(recordItem)
+	})
+}
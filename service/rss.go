@@ -1,15 +1,15 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/rhinoc/rss_feishu_bot/config"
-	"github.com/rhinoc/rss_feishu_bot/model"
 	"github.com/rhinoc/rss_feishu_bot/util"
 )
 
@@ -18,31 +18,121 @@ type RssFeed struct {
 	Link      string
 	UpdatedAt *time.Time
 	Items     []RssFeedItem
+	// new items held back by the item limit, see FeedCatchUpMore
+	MoreItemCount int
+	// new items matching an alert of the subscriber, whatever the filters and the item limit
+	AlertItems []RssFeedItem
 }
 
 type RssFeedItem struct {
+	Id          string `json:"id"`
 	Title       string `json:"title"`
 	Link        string `json:"link"`
 	Description string `json:"description"`
+	// plain text of the item description or content
+	Summary    string   `json:"summary"`
+	Author     string   `json:"author,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	ImageUrl   string   `json:"image_url,omitempty"`
+	// podcast episode
+	Enclosure   *RssFeedItemEnclosure `json:"enclosure,omitempty"`
+	Duration    time.Duration         `json:"duration,omitempty"`
+	PublishedAt *time.Time            `json:"published_at,omitempty"`
+	UpdatedAt   *time.Time            `json:"updated_at,omitempty"`
 }
 
-func GetRssFeed(url string, limit int) (*RssFeed, error) {
-	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(url)
+// Time returns when the item was published, falling back to when it was updated.
+func (item RssFeedItem) Time() *time.Time {
+	if item.PublishedAt != nil {
+		return item.PublishedAt
+	}
+	return item.UpdatedAt
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error parsing rss feed: %w", err)
+// TimeLabel renders Time relative to now, falling back to the raw date string of the feed.
+func (item RssFeedItem) TimeLabel(now time.Time) string {
+	if t := item.Time(); t != nil {
+		return util.FormatRelativeTime(*t, now, config.TimeLocation)
+	}
+	return item.Description
+}
+
+func getRssFeedItemAuthor(item *gofeed.Item) string {
+	names := []string{}
+	for _, author := range item.Authors {
+		if author != nil && author.Name != "" {
+			names = append(names, author.Name)
+		}
+	}
+	if len(names) == 0 && item.Author != nil {
+		names = append(names, item.Author.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func getRssFeedItemSummary(item *gofeed.Item) string {
+	summary := util.HtmlToText(item.Description)
+	if summary == "" {
+		summary = util.HtmlToText(item.Content)
 	}
+	return summary
+}
 
+type rssDigestItem struct {
+	RssFeedItem
+	// position of the feed in the record
+	FeedIndex   int
+	FeedTitle   string
+	FeedColor   string
+	ShowSummary bool
+	ImageKey    string
+	// feeds that carried the same item, see dedupeRssDigestItems
+	OtherFeeds []rssDigestFeedTag
+	// stands for held back items, see getMoreDigestItem
+	More bool
+}
+
+// getSummaryMarkdown renders the summary line shown under an item in cards.
+func (item rssDigestItem) getSummaryMarkdown() string {
+	if !item.ShowSummary || item.Summary == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n<font color='grey'>%s</font>", util.EscapeMarkdown(util.TruncateText(item.Summary, config.SummaryMaxLength)))
+}
+
+// sortRssDigestItems orders items newest first, items without a time last.
+func sortRssDigestItems(items []rssDigestItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, tj := items[i].Time(), items[j].Time()
+		if ti == nil || tj == nil {
+			return ti != nil
+		}
+		return ti.After(*tj)
+	})
+}
+
+func newRssFeed(feed *gofeed.Feed) *RssFeed {
 	items := []RssFeedItem{}
-	for i, item := range feed.Items {
-		if i >= limit {
-			break
+	for _, item := range feed.Items {
+		enclosure := getRssFeedItemEnclosure(item)
+		link := item.Link
+		if link == "" && enclosure != nil {
+			link = enclosure.Url
 		}
+
 		items = append(items, RssFeedItem{
+			Id:          item.GUID,
 			Title:       item.Title,
-			Link:        item.Link,
+			Link:        link,
 			Description: item.Published,
+			Summary:     getRssFeedItemSummary(item),
+			Author:      getRssFeedItemAuthor(item),
+			Categories:  item.Categories,
+			ImageUrl:    getRssFeedItemImage(item),
+			Enclosure:   enclosure,
+			Duration:    getRssFeedItemDuration(item),
+			PublishedAt: item.PublishedParsed,
+			UpdatedAt:   item.UpdatedParsed,
 		})
 	}
 
@@ -51,143 +141,286 @@ func GetRssFeed(url string, limit int) (*RssFeed, error) {
 		Link:      feed.Link,
 		UpdatedAt: feed.UpdatedParsed,
 		Items:     items,
-	}, nil
+	}
 }
 
-func FetchRSSFeedsParallel(feedURLs []string) ([]*gofeed.Feed, error) {
+// RssFetchBatchResult is the outcome of one request of FetchRSSFeedsParallel.
+type RssFetchBatchResult struct {
+	Url string
+	// may be set along with Err, e.g. for a non-2xx status
+	Result   *RssFetchResult
+	Err      error
+	Duration time.Duration
+}
+
+func (r RssFetchBatchResult) StatusCode() int {
+	if r.Result == nil {
+		return 0
+	}
+	return r.Result.StatusCode
+}
+
+// FetchRSSFeedsParallel fetches every request at once, through cache when it isn't nil, and returns
+// a result per request in the same order, so that what succeeded can be used whatever failed.
+func FetchRSSFeedsParallel(reqs []RssFetchRequest, cache *RssFetchCache) []RssFetchBatchResult {
 	var wg sync.WaitGroup
-	feeds := make([]*gofeed.Feed, len(feedURLs))
-	errors := make([]error, len(feedURLs))
+	results := make([]RssFetchBatchResult, len(reqs))
 
-	for i, url := range feedURLs {
+	for i, req := range reqs {
 		wg.Add(1)
-		go func(index int, feedURL string) {
+		go func() {
 			defer wg.Done()
-			fp := gofeed.NewParser()
-			feed, err := fp.ParseURL(feedURL)
-			if err != nil {
-				errors[index] = err
-				return
+			start := time.Now()
+			result, err := cache.Fetch(req)
+
+			results[i] = RssFetchBatchResult{
+				Url:      req.Url,
+				Result:   result,
+				Err:      err,
+				Duration: time.Since(start),
 			}
-			feeds[index] = feed
-		}(i, url)
+		}()
 	}
 
 	wg.Wait()
 
-	// Check for errors
-	for _, err := range errors {
-		if err != nil {
-			return nil, err
-		}
+	return results
+}
+
+func SendRssMessageByRecord(recordItem RecordItem, cache *RssFetchCache) error {
+	previousFeedState := getFeedStateDigest(recordItem.FeedList)
+	// read state before this run, restored when the digest can't be sent
+	previousFeeds := make([]RecordItemFeed, 0, len(recordItem.FeedList))
+	for _, feed := range recordItem.FeedList {
+		previousFeeds = append(previousFeeds, *feed)
 	}
 
-	return feeds, nil
-}
+	activeFeeds := []int{}
+	reqs := []RssFetchRequest{}
+	for recordIndex, recordItemFeed := range recordItem.FeedList {
+		if recordItemFeed.Suspended {
+			continue
+		}
+		activeFeeds = append(activeFeeds, recordIndex)
+		reqs = append(reqs, recordItemFeed.getFetchRequest())
+	}
 
-func SendRssMessageByRecord(recordItem RecordItem) error {
-	mu := sync.Mutex{}
-	wg := sync.WaitGroup{}
+	rssResults := make([][]rssDigestItem, len(recordItem.FeedList))
+	moreResults := make([]*rssDigestItem, len(recordItem.FeedList))
+	alertItems := []rssDigestItem{}
+	suspendedFeeds := []*RecordItemFeed{}
 
-	rssResults := make([][]model.FeishuMessageItem, len(recordItem.FeedList))
+	for i, fetchResult := range FetchRSSFeedsParallel(reqs, cache) {
+		recordIndex := activeFeeds[i]
+		recordItemFeed := recordItem.FeedList[recordIndex]
 
-	for recordIndex, recordItemFeed := range recordItem.FeedList {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			feed, err := GetRssFeedByRecordItemFeed(recordItemFeed)
-			if err != nil {
-				log.Println("error getting rss feed", err)
-				return
+		if fetchResult.Err != nil {
+			log.Println("error getting rss feed", recordItemFeed.Link, "status", fetchResult.StatusCode(), "after", fetchResult.Duration, fetchResult.Err)
+			if recordItemFeed.recordFailure(fetchResult.Err) {
+				suspendedFeeds = append(suspendedFeeds, recordItemFeed)
 			}
-			rssResult := []model.FeishuMessageItem{}
-			for _, item := range feed.Items {
-				rssResult = append(rssResult, model.FeishuMessageItem{
-					Title:            item.Title,
-					Link:             item.Link,
-					PrimaryDesc:      feed.Title,
-					PrimaryDescColor: util.GetColorByIndex(recordIndex),
-					SecondaryDesc:    item.Description,
-				})
-			}
-			mu.Lock()
-			rssResults[recordIndex] = rssResult
-			if len(feed.Items) > 0 {
-				recordItemFeed.LastReadLink = feed.Items[0].Link
+			continue
+		}
+		recordItemFeed.recordSuccess()
+
+		feed := filterRssFeedByRecordItemFeed(recordItemFeed, fetchResult.Result, recordItem.Settings.AlertList)
+		newDigestItem := func(item RssFeedItem) rssDigestItem {
+			return rssDigestItem{
+				RssFeedItem: item,
+				FeedIndex:   recordIndex,
+				FeedTitle:   feed.Title,
+				FeedColor:   util.GetColorByIndex(recordIndex),
+				ShowSummary: recordItemFeed.ShowSummary,
 			}
-			mu.Unlock()
-		}()
+		}
+		rssResult := []rssDigestItem{}
+		for _, item := range feed.Items {
+			rssResult = append(rssResult, newDigestItem(item))
+		}
+		rssResults[recordIndex] = rssResult
+		for _, item := range feed.AlertItems {
+			alertItems = append(alertItems, newDigestItem(item))
+		}
+		if feed.MoreItemCount > 0 {
+			moreItem := getMoreDigestItem(recordItemFeed, feed, recordIndex)
+			moreResults[recordIndex] = &moreItem
+		}
+	}
+
+	for _, feed := range suspendedFeeds {
+		notifyFeedSuspended(recordItem, feed)
 	}
-	wg.Wait()
 
-	items := []model.FeishuMessageItem{}
+	digestItems := []rssDigestItem{}
 	for _, rssResult := range rssResults {
-		items = append(items, rssResult...)
+		digestItems = append(digestItems, rssResult...)
+	}
+	sortRssDigestItems(digestItems)
+	digestItems = dedupeRssDigestItems(digestItems)
+	sortRssDigestItems(alertItems)
+	alertItems = dedupeRssDigestItems(alertItems)
+	itemCount := len(digestItems)
+	// after every item, rather than sorted in with them
+	for _, moreItem := range moreResults {
+		if moreItem != nil {
+			digestItems = append(digestItems, *moreItem)
+		}
 	}
 
-	if len(items) == 0 {
+	if len(digestItems) == 0 {
 		log.Println("no newer feeds found for record", recordItem.Id)
-		return nil
-	}
 
-	date := time.Now().Format("2006-01-02")
-	content := &model.FeishuMessageContent{
-		Type: "template",
-		Data: model.FeishuMessageData{
-			TemplateId:          config.CardTemplateId,
-			TemplateVersionName: config.CardTemplateVersionName,
-			TemplateVariable: map[string]interface{}{
-				// example: 2006-01-02 | Explore 10 New Updates
-				"cardTitle": fmt.Sprintf("%s | Explore %d New Updates", date, len(items)),
-				"cardColor": "blue",
-				"itemList":  items,
-			},
-		},
+		// still persist e.g. new validators so the next run can be answered with 304
+		if getFeedStateDigest(recordItem.FeedList) != previousFeedState {
+			err := GetRecordStore().UpdateRecordItemFeedState(recordItem)
+			if err != nil {
+				return fmt.Errorf("error updating record item feed state for record %s: %w", recordItem.Id, err)
+			}
+		}
+		sendRssAlerts(recordItem, alertItems)
+		return nil
 	}
 
-	targetOpenId := recordItem.UserOpenId
-	if recordItem.GroupOpenId != "" {
-		targetOpenId = recordItem.GroupOpenId
-	}
+	now := time.Now()
+	date := now.In(config.TimeLocation).Format("2006-01-02")
+	// example: 2006-01-02 | Explore 10 New Updates
+	cardTitle := fmt.Sprintf("%s | Explore %d New Updates", date, itemCount)
 
-	receiveIdType := "open_id"
-	if recordItem.GroupOpenId != "" {
-		receiveIdType = "chat_id"
+	var content string
+	if recordItem.Settings.Layout == DigestLayoutGrouped {
+		content = getGroupedDigestContent(recordItem.Settings, cardTitle, digestItems, itemCount, now)
+	} else {
+		content = getFlatDigestContent(cardTitle, digestItems, now)
 	}
 
+	targetOpenId, receiveIdType := getRecordItemReceiver(recordItem)
 	err := FeishuSendMessage(FeishuSendMessageRequest{
 		ReceiveId:     targetOpenId,
 		ReceiveIdType: receiveIdType,
 		MsgType:       "interactive",
-		Content:       string(util.Must(json.Marshal(content))),
+		Content:       content,
 	})
 
 	if err != nil {
+		// fetch the items again next run, but keep e.g. a suspension that was already notified
+		for i, feed := range recordItem.FeedList {
+			feed.restoreReadState(previousFeeds[i])
+		}
+		if err := GetRecordStore().UpdateRecordItemFeedState(recordItem); err != nil {
+			log.Println("error updating record item feed state for record", recordItem.Id, err)
+		}
 		return fmt.Errorf("error sending message for record %s: %w", recordItem.Id, err)
 	}
 
-	err = UpdateRecordItemLastReadLink(recordItem)
+	err = GetRecordStore().UpdateRecordItemFeedState(recordItem)
 	if err != nil {
-		return fmt.Errorf("error updating record item last read link for record %s: %w", recordItem.Id, err)
+		return fmt.Errorf("error updating record item feed state for record %s: %w", recordItem.Id, err)
 	}
+	sendRssAlerts(recordItem, alertItems)
 
 	return nil
 }
 
-func GetRssFeedByRecordItemFeed(recordItemFeed *RecordItemFeed) (*RssFeed, error) {
-	feed, err := GetRssFeed(recordItemFeed.Link, config.DefaultItemLimitPerFeed)
-	if err != nil {
-		return nil, err
+func (recordItemFeed *RecordItemFeed) getFetchRequest() RssFetchRequest {
+	return RssFetchRequest{
+		Url:          recordItemFeed.Link,
+		ETag:         recordItemFeed.ETag,
+		LastModified: recordItemFeed.LastModified,
+		Selector:     recordItemFeed.Selector,
+		Auth:         recordItemFeed.Auth,
+	}
+}
+
+// filterRssFeedByRecordItemFeed applies a fetch result to recordItemFeed: it returns the feed with only the items
+// not seen before, whatever their order, filtered and cut down to the item limit of the feed,
+// and records them in recordItemFeed.SeenItemList. New items matching any of alertList are taken out
+// into AlertItems first.
+func filterRssFeedByRecordItemFeed(recordItemFeed *RecordItemFeed, result *RssFetchResult, alertList []*RecordItemAlert) *RssFeed {
+	recordItemFeed.SetResolvedLink(result.Url)
+	recordItemFeed.ETag = result.ETag
+	recordItemFeed.LastModified = result.LastModified
+
+	feed := result.Feed
+	if result.NotModified {
+		return feed
+	}
+
+	// items deeper than the seen list can hold would come back as new
+	candidates := feed.Items
+	if len(candidates) > config.SeenItemLimitPerFeed {
+		candidates = candidates[:config.SeenItemLimitPerFeed]
 	}
 
-	if recordItemFeed.LastReadLink != "" {
-		for i, item := range feed.Items {
+	var newItems []RssFeedItem
+	switch {
+	case len(recordItemFeed.SeenItemList) > 0:
+		seen := make(map[string]bool, len(recordItemFeed.SeenItemList))
+		for _, key := range recordItemFeed.SeenItemList {
+			seen[key] = true
+		}
+		newItems = util.Filter(candidates, func(item RssFeedItem) bool {
+			return !seen[getRssFeedItemKey(item)]
+		})
+	case recordItemFeed.LastReadLink != "":
+		// migrate from the single last read link
+		newItems = candidates
+		for i, item := range candidates {
 			if item.Link == recordItemFeed.LastReadLink {
-				feed.Items = feed.Items[:i]
+				newItems = candidates[:i]
 				break
 			}
 		}
+	default:
+		// first run
+		newItems = candidates[:min(len(candidates), recordItemFeed.GetItemLimit())]
 	}
 
-	return feed, nil
+	newItems, feed.AlertItems = splitRssAlertItems(alertList, newItems)
+
+	// filtered items were still seen, so they don't come back once the filter changes
+	newItems = filterRssFeedItems(newItems, recordItemFeed.Filters)
+	feed.Items, feed.MoreItemCount = recordItemFeed.limitNewItems(newItems)
+
+	// every candidate, so that only items published from now on are new
+	markRssFeedItemsSeen(recordItemFeed, candidates)
+
+	return feed
+}
+
+// getRssFeedItemKey identifies an item by its guid, falling back to its link and then its title.
+func getRssFeedItemKey(item RssFeedItem) string {
+	if item.Id != "" {
+		return item.Id
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title
+}
+
+func markRssFeedItemsSeen(recordItemFeed *RecordItemFeed, items []RssFeedItem) {
+	if len(items) > 0 {
+		recordItemFeed.LastReadLink = items[0].Link
+	}
+
+	seenItemList := make([]string, 0, len(items)+len(recordItemFeed.SeenItemList))
+	seen := make(map[string]bool)
+	for _, item := range items {
+		key := getRssFeedItemKey(item)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			seenItemList = append(seenItemList, key)
+		}
+	}
+	for _, key := range recordItemFeed.SeenItemList {
+		if !seen[key] {
+			seen[key] = true
+			seenItemList = append(seenItemList, key)
+		}
+	}
+
+	if len(seenItemList) > config.SeenItemLimitPerFeed {
+		seenItemList = seenItemList[:config.SeenItemLimitPerFeed]
+	}
+	recordItemFeed.SeenItemList = seenItemList
 }
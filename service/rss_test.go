@@ -0,0 +1,261 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rhinoc/rss_feishu_bot/model"
+)
+
+func newTestRssFeedItems(keys ...string) []RssFeedItem {
+	items := []RssFeedItem{}
+	for _, key := range keys {
+		items = append(items, RssFeedItem{Id: key, Title: key, Link: "https://example.com/" + key})
+	}
+	return items
+}
+
+func getTestRssFeedItemKeys(items []RssFeedItem) []string {
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, getRssFeedItemKey(item))
+	}
+	return keys
+}
+
+func TestFilterRssFeedByRecordItemFeed(t *testing.T) {
+	type run struct {
+		// keys of the items served, in feed order
+		items    []string
+		want     []string
+		wantMore int
+	}
+
+	tests := []struct {
+		name string
+		feed RecordItemFeed
+		runs []run
+	}{
+		{
+			name: "newest first",
+			feed: RecordItemFeed{SeenItemList: []string{"c", "b", "a"}},
+			runs: []run{
+				{items: []string{"d", "c", "b", "a"}, want: []string{"d"}},
+				{items: []string{"f", "e", "d", "c", "b", "a"}, want: []string{"f", "e"}},
+				{items: []string{"f", "e", "d", "c", "b", "a"}, want: []string{}},
+			},
+		},
+		{
+			name: "oldest first",
+			feed: RecordItemFeed{SeenItemList: []string{"a", "b", "c"}},
+			runs: []run{
+				{items: []string{"a", "b", "c", "d"}, want: []string{"d"}},
+				{items: []string{"a", "b", "c", "d", "e"}, want: []string{"e"}},
+				{items: []string{"a", "b", "c", "d", "e"}, want: []string{}},
+			},
+		},
+		{
+			name: "reordered",
+			feed: RecordItemFeed{SeenItemList: []string{"a", "b", "c"}},
+			runs: []run{
+				{items: []string{"b", "x", "a", "c"}, want: []string{"x"}},
+				{items: []string{"c", "a", "x", "b"}, want: []string{}},
+			},
+		},
+		{
+			name: "first run",
+			feed: RecordItemFeed{ItemLimit: 2},
+			runs: []run{
+				{items: []string{"d", "c", "b", "a"}, want: []string{"d", "c"}},
+				// the rest was seen, not held back for the next run
+				{items: []string{"e", "d", "c", "b", "a"}, want: []string{"e"}},
+			},
+		},
+		{
+			name: "migration from the last read link",
+			feed: RecordItemFeed{LastReadLink: "https://example.com/c"},
+			runs: []run{
+				{items: []string{"e", "d", "c", "b", "a"}, want: []string{"e", "d"}},
+				{items: []string{"e", "d", "c", "b", "a"}, want: []string{}},
+			},
+		},
+		{
+			name: "migration from a last read link no longer served",
+			feed: RecordItemFeed{LastReadLink: "https://example.com/gone", ItemLimit: 2},
+			runs: []run{
+				{items: []string{"c", "b", "a"}, want: []string{"c", "b"}},
+				{items: []string{"d", "c", "b", "a"}, want: []string{"d"}},
+			},
+		},
+		{
+			name: "item limit with more",
+			feed: RecordItemFeed{SeenItemList: []string{"a"}, ItemLimit: 2, CatchUp: FeedCatchUpMore},
+			runs: []run{
+				{items: []string{"f", "e", "d", "c", "b", "a"}, want: []string{"f", "e"}, wantMore: 3},
+				{items: []string{"f", "e", "d", "c", "b", "a"}, want: []string{}},
+			},
+		},
+		{
+			name: "filtered items are seen",
+			feed: RecordItemFeed{SeenItemList: []string{"a"}, Filters: []string{"-title:b"}},
+			runs: []run{
+				{items: []string{"c", "b", "a"}, want: []string{"c"}},
+				{items: []string{"c", "b", "a"}, want: []string{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordItemFeed := tt.feed
+			for i, run := range tt.runs {
+				feed := filterRssFeedByRecordItemFeed(&recordItemFeed, &RssFetchResult{
+					Feed: &RssFeed{Items: newTestRssFeedItems(run.items...)},
+				}, nil)
+
+				if got := getTestRssFeedItemKeys(feed.Items); !slices.Equal(got, run.want) {
+					t.Errorf("run %d: items = %v, want %v", i, got, run.want)
+				}
+				if feed.MoreItemCount != run.wantMore {
+					t.Errorf("run %d: more = %d, want %d", i, feed.MoreItemCount, run.wantMore)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterRssFeedByRecordItemFeedNotModified(t *testing.T) {
+	recordItemFeed := RecordItemFeed{SeenItemList: []string{"a"}, ETag: `"1"`}
+	feed := filterRssFeedByRecordItemFeed(&recordItemFeed, &RssFetchResult{
+		Feed:        &RssFeed{},
+		NotModified: true,
+		ETag:        `"1"`,
+	}, nil)
+
+	if len(feed.Items) != 0 {
+		t.Errorf("items = %v, want none", getTestRssFeedItemKeys(feed.Items))
+	}
+	if !slices.Equal(recordItemFeed.SeenItemList, []string{"a"}) {
+		t.Errorf("seen items = %v, want [a]", recordItemFeed.SeenItemList)
+	}
+}
+
+func TestFilterRssFeedByRecordItemFeedAlerts(t *testing.T) {
+	// below the item limit and excluded by a filter, an urgent item is still alerted
+	recordItemFeed := RecordItemFeed{SeenItemList: []string{"a"}, ItemLimit: 1, Filters: []string{"-urgent"}}
+	alertList := []*RecordItemAlert{{Rule: "urgent"}}
+
+	feed := filterRssFeedByRecordItemFeed(&recordItemFeed, &RssFetchResult{
+		Feed: &RssFeed{Items: newTestRssFeedItems("x", "y", "urgent-1", "a")},
+	}, alertList)
+
+	if got := getTestRssFeedItemKeys(feed.Items); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("items = %v, want [x]", got)
+	}
+	if got := getTestRssFeedItemKeys(feed.AlertItems); !slices.Equal(got, []string{"urgent-1"}) {
+		t.Errorf("alert items = %v, want [urgent-1]", got)
+	}
+}
+
+// newTestRecordStore points GetRecordStore at a local store in a temporary directory until the test ends.
+func newTestRecordStore(t *testing.T) RecordStore {
+	store, err := NewLocalRecordStore(filepath.Join(t.TempDir(), "records.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordStoreOnce.Do(func() {})
+	previous := recordStore
+	recordStore = store
+	t.Cleanup(func() { recordStore = previous })
+
+	return store
+}
+
+// getTestDigestTitles returns the item titles of the digest cards sent, one list per card.
+func getTestDigestTitles(t *testing.T, messages []FeishuSendMessageRequest) [][]string {
+	cards := [][]string{}
+	for _, message := range messages {
+		if message.MsgType != "interactive" {
+			continue
+		}
+		var content struct {
+			Data struct {
+				TemplateVariable struct {
+					ItemList []model.FeishuMessageItem `json:"itemList"`
+				} `json:"template_variable"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+			t.Fatalf("error decoding card %q: %v", message.Content, err)
+		}
+		titles := []string{}
+		for _, item := range content.Data.TemplateVariable.ItemList {
+			titles = append(titles, item.Title)
+		}
+		cards = append(cards, titles)
+	}
+	return cards
+}
+
+func TestSendRssMessageByRecord(t *testing.T) {
+	standIn := newFeishuStandIn(t)
+	store := newTestRecordStore(t)
+
+	var mu sync.Mutex
+	keys := []string{"b", "a"}
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		items := []string{}
+		for _, key := range keys {
+			items = append(items, fmt.Sprintf("<item><guid>%s</guid><title>%s</title><link>https://example.com/%s</link></item>", key, key, key))
+		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title>%s</channel></rss>`, strings.Join(items, ""))
+	}))
+	t.Cleanup(feedServer.Close)
+
+	_, err := store.AddRecordItem(RecordItem{GroupOpenId: "oc_test", FeedList: []*RecordItemFeed{{Link: feedServer.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() {
+		recordItem, err := store.GetRecordItem("oc_test", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SendRssMessageByRecord(*recordItem, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send()
+	mu.Lock()
+	keys = []string{"c", "b", "a"}
+	mu.Unlock()
+	send()
+	// nothing new, so no card
+	send()
+
+	standIn.mu.Lock()
+	messages := standIn.messages
+	standIn.mu.Unlock()
+
+	for _, message := range messages {
+		if message.ReceiveId != "oc_test" || message.ReceiveIdType != "chat_id" {
+			t.Errorf("message sent to %s %s, want chat_id oc_test", message.ReceiveIdType, message.ReceiveId)
+		}
+	}
+	want := [][]string{{"b", "a"}, {"c"}}
+	if got := getTestDigestTitles(t, messages); !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("digest titles = %v, want %v", got, want)
+	}
+}
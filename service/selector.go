@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+var ErrSelectorNoMatch = errors.New("the selector matched no items")
+
+// RecordItemFeedSelector turns an HTML page into feed items, for sites without a feed.
+type RecordItemFeedSelector struct {
+	// each match is one item
+	Item string `json:"item"`
+	// optional, matched inside the item, defaults to the item itself
+	Title string `json:"title,omitempty"`
+	// optional, matched inside the item, defaults to the item itself when it is a link or else its first link
+	Link string `json:"link,omitempty"`
+}
+
+// Validate makes sure every selector compiles, so a typo is rejected on /add instead of on every run.
+func (selector *RecordItemFeedSelector) Validate() error {
+	if selector.Item == "" {
+		return fmt.Errorf("the item selector is required")
+	}
+	for _, sel := range []string{selector.Item, selector.Title, selector.Link} {
+		if sel == "" {
+			continue
+		}
+		if _, err := cascadia.ParseGroup(sel); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", sel, err)
+		}
+	}
+	return nil
+}
+
+func extractHtmlFeed(pageUrl string, body []byte, selector *RecordItemFeedSelector) (*RssFeed, error) {
+	if err := selector.Validate(); err != nil {
+		return nil, err
+	}
+
+	base, err := neturl.Parse(pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing page url: %w", err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html: %w", err)
+	}
+
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if baseHref, err := base.Parse(href); err == nil {
+			base = baseHref
+		}
+	}
+
+	items := []RssFeedItem{}
+	seen := make(map[string]bool)
+	doc.Find(selector.Item).Each(func(_ int, s *goquery.Selection) {
+		titleSelection := s
+		if selector.Title != "" {
+			titleSelection = s.Find(selector.Title).First()
+		}
+
+		linkSelection := s
+		if selector.Link != "" {
+			linkSelection = s.Find(selector.Link).First()
+		} else if !s.Is("a[href]") {
+			linkSelection = s.Find("a[href]").First()
+		}
+
+		title := strings.Join(strings.Fields(titleSelection.Text()), " ")
+		link := ""
+		if href, ok := linkSelection.Attr("href"); ok {
+			if resolved, err := base.Parse(strings.TrimSpace(href)); err == nil {
+				link = resolved.String()
+			}
+		}
+
+		item := RssFeedItem{
+			Title: title,
+			Link:  link,
+		}
+		key := getRssFeedItemKey(item)
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		items = append(items, item)
+	})
+
+	if len(items) == 0 {
+		return nil, ErrSelectorNoMatch
+	}
+
+	return &RssFeed{
+		Title: strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:  pageUrl,
+		Items: items,
+	}, nil
+}
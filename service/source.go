@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SourceAdapter turns a short source uri such as "github:golang/go/releases" into something fetchable.
+// Register new adapters with RegisterSourceAdapter.
+type SourceAdapter interface {
+	// Scheme is the uri prefix before the colon, e.g. "github".
+	Scheme() string
+	// Canonical parses what the user typed after the colon, e.g. "golang/go releases", into the stored uri.
+	Canonical(ref string) (string, error)
+	// Expand resolves a stored uri into a concrete feed url.
+	Expand(uri string) (string, error)
+}
+
+// SourceFetcher is implemented by adapters whose source isn't a feed at all, they fetch the items themselves.
+type SourceFetcher interface {
+	FetchSource(req RssFetchRequest) (*RssFetchResult, error)
+}
+
+var (
+	sourceAdaptersMu sync.RWMutex
+	sourceAdapters   = map[string]SourceAdapter{}
+)
+
+func RegisterSourceAdapter(adapter SourceAdapter) {
+	sourceAdaptersMu.Lock()
+	defer sourceAdaptersMu.Unlock()
+	sourceAdapters[adapter.Scheme()] = adapter
+}
+
+// GetSourceAdapter returns the adapter handling uri, or nil for plain urls.
+func GetSourceAdapter(uri string) SourceAdapter {
+	scheme, _, ok := strings.Cut(uri, ":")
+	if !ok {
+		return nil
+	}
+
+	sourceAdaptersMu.RLock()
+	defer sourceAdaptersMu.RUnlock()
+	return sourceAdapters[strings.ToLower(scheme)]
+}
+
+// ExtractSourceUri finds a source uri of a registered scheme in a command, e.g. "/add github:golang/go releases",
+// and returns it in canonical form. Arguments run until the next flag.
+func ExtractSourceUri(text string) (string, error) {
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		adapter := GetSourceAdapter(field)
+		if adapter == nil {
+			continue
+		}
+
+		_, ref, _ := strings.Cut(field, ":")
+		for _, arg := range fields[i+1:] {
+			if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "/") {
+				break
+			}
+			ref += " " + arg
+		}
+
+		uri, err := adapter.Canonical(ref)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s source: %w", adapter.Scheme(), err)
+		}
+		return uri, nil
+	}
+
+	return "", nil
+}
+
+var (
+	expandedSourcesMu sync.Mutex
+	expandedSources   = map[string]string{}
+)
+
+func fetchSource(adapter SourceAdapter, req RssFetchRequest) (*RssFetchResult, error) {
+	if fetcher, ok := adapter.(SourceFetcher); ok {
+		return fetcher.FetchSource(req)
+	}
+
+	expandedSourcesMu.Lock()
+	url, ok := expandedSources[req.Url]
+	expandedSourcesMu.Unlock()
+
+	if !ok {
+		var err error
+		url, err = adapter.Expand(req.Url)
+		if err != nil {
+			return nil, fmt.Errorf("error expanding %s: %w", req.Url, err)
+		}
+
+		expandedSourcesMu.Lock()
+		expandedSources[req.Url] = url
+		expandedSourcesMu.Unlock()
+	}
+
+	req.Url = url
+	return defaultRssFetcher.Fetch(req)
+}
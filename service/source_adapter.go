@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+)
+
+func init() {
+	RegisterSourceAdapter(githubSourceAdapter{})
+	RegisterSourceAdapter(youtubeSourceAdapter{})
+	RegisterSourceAdapter(redditSourceAdapter{})
+}
+
+// github:<owner> for public activity, github:<owner>/<repo>[/releases|tags|commits]
+type githubSourceAdapter struct{}
+
+var githubFeedKinds = map[string]bool{"releases": true, "tags": true, "commits": true}
+
+func (githubSourceAdapter) Scheme() string {
+	return "github"
+}
+
+func (githubSourceAdapter) Canonical(ref string) (string, error) {
+	parts := strings.FieldsFunc(ref, func(r rune) bool { return r == '/' || r == ' ' })
+	switch len(parts) {
+	case 1:
+		return "github:" + parts[0], nil
+	case 2:
+		return fmt.Sprintf("github:%s/%s/releases", parts[0], parts[1]), nil
+	case 3:
+		if !githubFeedKinds[parts[2]] {
+			return "", fmt.Errorf("unknown feed %q, expected releases, tags or commits", parts[2])
+		}
+		return fmt.Sprintf("github:%s/%s/%s", parts[0], parts[1], parts[2]), nil
+	default:
+		return "", fmt.Errorf("expected github:<owner>/<repo> [releases|tags|commits]")
+	}
+}
+
+func (githubSourceAdapter) Expand(uri string) (string, error) {
+	return "https://github.com/" + strings.TrimPrefix(uri, "github:") + ".atom", nil
+}
+
+// youtube:@<handle>, youtube:<channel id> or youtube:playlist/<playlist id>
+type youtubeSourceAdapter struct{}
+
+func (youtubeSourceAdapter) Scheme() string {
+	return "youtube"
+}
+
+func (youtubeSourceAdapter) Canonical(ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.Contains(ref, " ") {
+		return "", fmt.Errorf("expected youtube:@<handle>, youtube:<channel id> or youtube:playlist/<playlist id>")
+	}
+	return "youtube:" + ref, nil
+}
+
+func (youtubeSourceAdapter) Expand(uri string) (string, error) {
+	ref := strings.TrimPrefix(uri, "youtube:")
+	switch {
+	case strings.HasPrefix(ref, "playlist/"):
+		return "https://www.youtube.com/feeds/videos.xml?playlist_id=" + neturl.QueryEscape(strings.TrimPrefix(ref, "playlist/")), nil
+	case strings.HasPrefix(ref, "@"):
+		// channel pages advertise their feed, which carries the channel id
+		feeds, err := DiscoverFeeds("https://www.youtube.com/" + neturl.PathEscape(ref))
+		if err != nil {
+			return "", err
+		}
+		return feeds[0].Link, nil
+	default:
+		return "https://www.youtube.com/feeds/videos.xml?channel_id=" + neturl.QueryEscape(ref), nil
+	}
+}
+
+// reddit:r/<subreddit> [hot|new|top|rising] or reddit:u/<user>
+type redditSourceAdapter struct{}
+
+var redditSorts = map[string]bool{"hot": true, "new": true, "top": true, "rising": true}
+
+func (redditSourceAdapter) Scheme() string {
+	return "reddit"
+}
+
+func (redditSourceAdapter) Canonical(ref string) (string, error) {
+	parts := strings.FieldsFunc(ref, func(r rune) bool { return r == '/' || r == ' ' })
+	if len(parts) == 0 {
+		return "", fmt.Errorf("expected reddit:r/<subreddit> or reddit:u/<user>")
+	}
+
+	kind := "r"
+	if parts[0] == "r" || parts[0] == "u" || parts[0] == "user" {
+		kind = strings.TrimSuffix(parts[0], "ser")
+		parts = parts[1:]
+	}
+
+	switch {
+	case len(parts) == 1:
+		return fmt.Sprintf("reddit:%s/%s", kind, parts[0]), nil
+	case len(parts) == 2 && kind == "r" && redditSorts[parts[1]]:
+		return fmt.Sprintf("reddit:r/%s/%s", parts[0], parts[1]), nil
+	default:
+		return "", fmt.Errorf("expected reddit:r/<subreddit> [hot|new|top|rising] or reddit:u/<user>")
+	}
+}
+
+func (redditSourceAdapter) Expand(uri string) (string, error) {
+	ref := strings.TrimPrefix(uri, "reddit:")
+	if strings.HasPrefix(ref, "u/") {
+		ref = "user/" + strings.TrimPrefix(ref, "u/")
+	}
+	return "https://www.reddit.com/" + ref + "/.rss", nil
+}
@@ -0,0 +1,47 @@
+package util
+
+import "strings"
+
+var quotePairs = map[rune]rune{
+	'"':  '"',
+	'\'': '\'',
+	'“':  '”',
+	'‘':  '’',
+}
+
+// SplitCommandArgs splits a chat command on whitespace, keeping quoted arguments together.
+// Feishu clients may turn straight quotes into curly ones, so both are accepted.
+func SplitCommandArgs(text string) []string {
+	args := []string{}
+	current := strings.Builder{}
+	inArg := false
+	var closing rune
+
+	for _, r := range text {
+		switch {
+		case closing != 0:
+			if r == closing {
+				closing = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quotePairs[r] != 0:
+			closing = quotePairs[r]
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
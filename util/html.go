@@ -0,0 +1,56 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "li": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "tr": true, "ul": true,
+}
+
+// HtmlToText strips tags from an HTML fragment and collapses whitespace into single spaces.
+func HtmlToText(source string) string {
+	builder := strings.Builder{}
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	skip := 0
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(builder.String()), " ")
+		case html.TextToken:
+			if skip == 0 {
+				builder.Write(tokenizer.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "script" || tag == "style" {
+				if tokenType == html.StartTagToken {
+					skip++
+				} else if tokenType == html.EndTagToken {
+					skip = max(skip-1, 0)
+				}
+			}
+			if blockTags[tag] {
+				builder.WriteString(" ")
+			}
+		}
+	}
+}
+
+// TruncateText cuts text to at most limit runes, ending with an ellipsis when cut.
+func TruncateText(text string, limit int) string {
+	runes := []rune(text)
+	if limit <= 0 || len(runes) <= limit {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
@@ -0,0 +1,25 @@
+package util
+
+import "strings"
+
+// https://open.feishu.cn/document/common-capabilities/message-card/message-cards-content/using-markdown-tags
+var markdownReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"*", "&#42;",
+	"_", "&#95;",
+	"~", "&sim;",
+	"`", "&#96;",
+	"[", "&#91;",
+	"]", "&#93;",
+	"(", "&#40;",
+	")", "&#41;",
+	"#", "&#35;",
+	"$", "&#36;",
+)
+
+// EscapeMarkdown makes text render literally inside a lark_md / markdown card element.
+func EscapeMarkdown(text string) string {
+	return markdownReplacer.Replace(text)
+}
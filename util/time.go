@@ -0,0 +1,25 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// FormatRelativeTime renders t as "3h ago" within a day of now, otherwise as "Oct 12 14:05" in loc.
+func FormatRelativeTime(t time.Time, now time.Time, loc *time.Location) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	}
+
+	t = t.In(loc)
+	if t.Year() == now.In(loc).Year() {
+		return t.Format("Jan 2 15:04")
+	}
+	return t.Format("Jan 2, 2006")
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	neturl "net/url"
+	"sort"
+	"strings"
+)
+
+// trailing characters picked up with a url from chat text, e.g. "see https://example.com/feed."
+const urlTrailingPunctuation = `.,;:!?'"”’。，；：！？、`
+
+var urlClosingBrackets = map[string]string{
+	")": "(",
+	"]": "[",
+	">": "<",
+	"）": "（",
+	"》": "《",
+	"」": "「",
+}
+
+var urlTrackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+}
+
+// TrimUrl drops what was picked up around a url from chat text, leaving the url itself as given.
+func TrimUrl(raw string) string {
+	return trimUrlPunctuation(strings.TrimSpace(raw))
+}
+
+// NormalizeUrl returns the canonical form of a feed url: trailing punctuation trimmed, scheme and host
+// lowercased, default port, fragment and tracking parameters dropped, query sorted and no trailing slash.
+// Anything that isn't an http(s) url is returned trimmed. It is only meant for comparing urls,
+// as a server may well serve a different url than its canonical form, see UrlKey.
+func NormalizeUrl(raw string) string {
+	raw = TrimUrl(raw)
+
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return raw
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// sorted as is, so that signed or unusually encoded values survive
+	params := []string{}
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		key, _, _ := strings.Cut(param, "=")
+		key = strings.ToLower(key)
+		if param == "" || strings.HasPrefix(key, "utm_") || urlTrackingParams[key] {
+			continue
+		}
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	return u.String()
+}
+
+// UrlKey identifies a feed url regardless of its form, http and https included.
+func UrlKey(raw string) string {
+	normalized := NormalizeUrl(raw)
+	if _, rest, ok := strings.Cut(normalized, "://"); ok && strings.HasPrefix(normalized, "http") {
+		return rest
+	}
+	return normalized
+}
+
+func trimUrlPunctuation(raw string) string {
+	for {
+		trimmed := strings.TrimRight(raw, urlTrailingPunctuation)
+		for closing, opening := range urlClosingBrackets {
+			// keep balanced brackets, e.g. https://en.wikipedia.org/wiki/Go_(programming_language)
+			if strings.HasSuffix(trimmed, closing) && strings.Count(trimmed, opening) < strings.Count(trimmed, closing) {
+				trimmed = strings.TrimSuffix(trimmed, closing)
+			}
+		}
+		if trimmed == raw {
+			return raw
+		}
+		raw = trimmed
+	}
+}